@@ -3,8 +3,11 @@ module github.com/pch/mediator
 go 1.22.0
 
 require (
-	github.com/davidbyttow/govips/v2 v2.14.0 // indirect
-	golang.org/x/image v0.15.0 // indirect
+	github.com/davidbyttow/govips/v2 v2.14.0
+	golang.org/x/image v0.15.0
+)
+
+require (
 	golang.org/x/net v0.22.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
 )
 
 const (
@@ -41,6 +46,11 @@ type Config struct {
 	SecretKey    string
 	AuthToken    string
 	MaxConcurrentTransforms int
+	MaxAnimationFrames      int
+	AutoFormats             []vips.ImageType
+	EncoderDefaults         url.Values
+	FormatQuality           map[vips.ImageType]int
+	AutoQualityTarget       float64
 	CacheControl            string
 	PathPrefix              string
 
@@ -61,11 +71,26 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
+	autoFormats, err := parseImageTypes(getEnvString("MEDIATOR_AUTO_FORMATS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("MEDIATOR_AUTO_FORMATS: %w", err)
+	}
+
+	encoderDefaults, err := getEncoderDefaults("MEDIATOR_ENCODER_DEFAULTS")
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		DownloadMaxSize: getEnvInt("MEDIATOR_DOWNLOAD_MAX_SIZE", defaultDownloadMaxSize),
 		DownloadTimeout: getEnvDuration("MEDIATOR_DOWNLOAD_TIMEOUT", defaultDownloadTimeout),
 
 		MaxConcurrentTransforms: getEnvInt("MEDIATOR_MAX_CONCURRENT_TRANSFORMS", defaultMaxConcurrentTransforms),
+		MaxAnimationFrames:      getEnvInt("MEDIATOR_MAX_ANIMATION_FRAMES", defaultMaxAnimationFrames),
+		AutoFormats:             autoFormats,
+		EncoderDefaults:         encoderDefaults,
+		FormatQuality:           getFormatQuality(),
+		AutoQualityTarget:       getAutoQualityTarget("MEDIATOR_AUTO_QUALITY_SSIM"),
 
 		Sources: sources,
 		Renderers:    renderers,
@@ -104,6 +129,20 @@ func getEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(floatValue) {
+		return defaultValue
+	}
+
+	return floatValue
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	value := os.Getenv(key)
 	if value == "" {
@@ -136,6 +175,59 @@ func getSourceConfigs(key string) ([]SourceConfig, error) {
 	return result, nil
 }
 
+// getAutoQualityTarget reads the minimum SSIM for q=auto. Values outside (0, 1)
+// would pin the search to one end of the quality range.
+func getAutoQualityTarget(key string) float64 {
+	target := getEnvFloat(key, defaultAutoQualityTarget)
+	if target <= 0 || target >= 1 {
+		slog.Error("Invalid auto quality target, using the default", "key", key, "value", target, "default", defaultAutoQualityTarget)
+		return defaultAutoQualityTarget
+	}
+
+	return target
+}
+
+// Environment variables with the default quality for each output format.
+var formatQualityEnvVars = map[vips.ImageType]string{
+	vips.ImageTypeJPEG: "MEDIATOR_QUALITY_JPEG",
+	vips.ImageTypePNG:  "MEDIATOR_QUALITY_PNG",
+	vips.ImageTypeWEBP: "MEDIATOR_QUALITY_WEBP",
+	vips.ImageTypeGIF:  "MEDIATOR_QUALITY_GIF",
+	vips.ImageTypeAVIF: "MEDIATOR_QUALITY_AVIF",
+	vips.ImageTypeHEIF: "MEDIATOR_QUALITY_HEIF",
+	vips.ImageTypeJXL:  "MEDIATOR_QUALITY_JXL",
+	vips.ImageTypeTIFF: "MEDIATOR_QUALITY_TIFF",
+}
+
+func getFormatQuality() map[vips.ImageType]int {
+	result := make(map[vips.ImageType]int)
+
+	for imageType, key := range formatQualityEnvVars {
+		if quality := getEnvInt(key, 0); quality > 0 {
+			result[imageType] = min(quality, 100)
+		}
+	}
+
+	return result
+}
+
+// getEncoderDefaults parses encoder params given as a query string, e.g.
+// "progressive=true&effort=6", applied to requests which don't set them.
+func getEncoderDefaults(key string) (url.Values, error) {
+	values, err := url.ParseQuery(os.Getenv(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid query string: %w", key, err)
+	}
+
+	for name := range values {
+		if !slices.Contains(encoderParams, name) {
+			return nil, fmt.Errorf("%s: unsupported param: %s", key, name)
+		}
+	}
+
+	return values, nil
+}
+
 func (c *Config) FindSourceByName(name string) (string, bool) {
 	for _, source := range c.Sources {
 		if source.Name == name {
@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
 )
 
 func TestNewConfigDefaults(t *testing.T) {
@@ -88,3 +90,82 @@ func TestNewConfigInvalidSourceJSON(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestNewConfigAutoFormats(t *testing.T) {
+	t.Setenv("MEDIATOR_AUTO_FORMATS", "webp, jpeg")
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error: %v", err)
+	}
+	if len(cfg.AutoFormats) != 2 || cfg.AutoFormats[0] != vips.ImageTypeWEBP || cfg.AutoFormats[1] != vips.ImageTypeJPEG {
+		t.Fatalf("AutoFormats = %v", cfg.AutoFormats)
+	}
+
+	t.Setenv("MEDIATOR_AUTO_FORMATS", "webp,nope")
+
+	_, err = NewConfig()
+	if err == nil || !strings.Contains(err.Error(), "MEDIATOR_AUTO_FORMATS") {
+		t.Fatalf("expected MEDIATOR_AUTO_FORMATS error, got %v", err)
+	}
+}
+
+func TestNewConfigEncoderDefaults(t *testing.T) {
+	t.Setenv("MEDIATOR_ENCODER_DEFAULTS", "progressive=true&effort=6")
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error: %v", err)
+	}
+	if cfg.EncoderDefaults.Get("progressive") != "true" || cfg.EncoderDefaults.Get("effort") != "6" {
+		t.Fatalf("EncoderDefaults = %v", cfg.EncoderDefaults)
+	}
+
+	t.Setenv("MEDIATOR_ENCODER_DEFAULTS", "w=100")
+
+	_, err = NewConfig()
+	if err == nil || !strings.Contains(err.Error(), "MEDIATOR_ENCODER_DEFAULTS") {
+		t.Fatalf("expected MEDIATOR_ENCODER_DEFAULTS error, got %v", err)
+	}
+}
+
+func TestNewConfigFormatQuality(t *testing.T) {
+	t.Setenv("MEDIATOR_QUALITY_AVIF", "55")
+	t.Setenv("MEDIATOR_QUALITY_WEBP", "500")
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error: %v", err)
+	}
+
+	if cfg.FormatQuality[vips.ImageTypeAVIF] != 55 || cfg.FormatQuality[vips.ImageTypeWEBP] != 100 {
+		t.Fatalf("FormatQuality = %v", cfg.FormatQuality)
+	}
+	if _, exists := cfg.FormatQuality[vips.ImageTypeJPEG]; exists {
+		t.Fatalf("JPEG quality should not be set")
+	}
+}
+
+func TestNewConfigAutoQualityTarget(t *testing.T) {
+	t.Setenv("MEDIATOR_AUTO_QUALITY_SSIM", "0.95")
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error: %v", err)
+	}
+	if cfg.AutoQualityTarget != 0.95 {
+		t.Fatalf("AutoQualityTarget = %v, want 0.95", cfg.AutoQualityTarget)
+	}
+
+	for _, value := range []string{"1", "1.5", "0", "-0.2", "nope"} {
+		t.Setenv("MEDIATOR_AUTO_QUALITY_SSIM", value)
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig() error: %v", err)
+		}
+		if cfg.AutoQualityTarget != defaultAutoQualityTarget {
+			t.Fatalf("AutoQualityTarget(%q) = %v, want %v", value, cfg.AutoQualityTarget, defaultAutoQualityTarget)
+		}
+	}
+}
@@ -23,7 +23,11 @@ func NewUnsourcedMediaHandler(config *Config, handler http.Handler) http.Handler
 }
 
 func NewHandler(config *Config) *http.ServeMux {
-	transformHandler := NewSourcedMediaHandler(config, NewImageTransformHandler(config))
+	imageTransformHandler := NewImageTransformHandler(config)
+	transformHandler := NewSourcedMediaHandler(config, imageTransformHandler)
+	infoHandler := NewSourcedMediaHandler(config, NewImageInfoHandler(imageTransformHandler))
+	paletteHandler := NewSourcedMediaHandler(config, NewImagePaletteHandler(imageTransformHandler))
+	placeholderHandler := NewSourcedMediaHandler(config, NewImagePlaceholderHandler(imageTransformHandler))
 	renderHandler := NewUnsourcedMediaHandler(config, NewRenderHandler(config))
 	defaultRouteHandler := NewLoggingMiddleware(NewDefaultRouteHandler())
 
@@ -32,6 +36,9 @@ func NewHandler(config *Config) *http.ServeMux {
 
 	mux := http.NewServeMux()
 	mux.Handle(pathPrefix+"/image/transform/{source}/{path...}", transformHandler)
+	mux.Handle(pathPrefix+"/image/info/{source}/{path...}", infoHandler)
+	mux.Handle(pathPrefix+"/image/palette/{source}/{path...}", paletteHandler)
+	mux.Handle(pathPrefix+"/image/placeholder/{source}/{path...}", placeholderHandler)
 	mux.Handle(pathPrefix+"/render/{renderer}/{payloadBase64}", renderHandler)
 	mux.Handle("/", defaultRouteHandler)
 
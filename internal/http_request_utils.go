@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -52,6 +53,31 @@ func getQueryParamIntWithDefault(name string, defaultValue int, r *http.Request)
 	return value
 }
 
+func getQueryParamFloat(name string, r *http.Request) (float64, bool) {
+	value, ok := getQueryParam(name, r)
+
+	if !ok {
+		return 0, false
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+		return 0, false
+	}
+
+	return floatValue, true
+}
+
+func getQueryParamFloatWithDefault(name string, defaultValue float64, r *http.Request) float64 {
+	value, ok := getQueryParamFloat(name, r)
+
+	if !ok {
+		return defaultValue
+	}
+
+	return value
+}
+
 func getQueryParamBool(name string, r *http.Request) (bool, bool) {
 	value, ok := getQueryParam(name, r)
 
@@ -83,6 +109,22 @@ func removeParamFromQuery(r *http.Request, paramName string) {
 	r.URL.RawQuery = queryValues.Encode()
 }
 
+// setDefaultQueryParams adds the given params to the request query, unless
+// they're already set.
+func setDefaultQueryParams(r *http.Request, defaults url.Values) {
+	if len(defaults) == 0 {
+		return
+	}
+
+	queryValues := r.URL.Query()
+	for name, values := range defaults {
+		if !queryValues.Has(name) {
+			queryValues[name] = values
+		}
+	}
+	r.URL.RawQuery = queryValues.Encode()
+}
+
 func currentRequestHost(r *http.Request) string {
 	scheme := "http"
 	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
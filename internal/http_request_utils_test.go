@@ -8,7 +8,7 @@ import (
 )
 
 func TestQueryHelpers(t *testing.T) {
-	r := httptest.NewRequest("GET", "http://example.com/?name=alice&n=7&flag=true&flag=false", nil)
+	r := httptest.NewRequest("GET", "http://example.com/?name=alice&n=7&x=1.5&bad=NaN&flag=true&flag=false", nil)
 
 	if got, ok := getQueryParam("name", r); !ok || got != "alice" {
 		t.Fatalf("getQueryParam(name) = (%q, %v)", got, ok)
@@ -28,6 +28,16 @@ func TestQueryHelpers(t *testing.T) {
 		t.Fatalf("getQueryParamIntWithDefault = %d", got)
 	}
 
+	if got, ok := getQueryParamFloat("x", r); !ok || got != 1.5 {
+		t.Fatalf("getQueryParamFloat(x) = (%v, %v)", got, ok)
+	}
+	if got, ok := getQueryParamFloat("bad", r); ok {
+		t.Fatalf("getQueryParamFloat(bad) = (%v, %v)", got, ok)
+	}
+	if got := getQueryParamFloatWithDefault("missing", 2.5, r); got != 2.5 {
+		t.Fatalf("getQueryParamFloatWithDefault = %v", got)
+	}
+
 	if got, ok := getQueryParamBool("flag", r); !ok || !got {
 		t.Fatalf("getQueryParamBool(flag) = (%v, %v)", got, ok)
 	}
@@ -105,3 +115,15 @@ func TestMergeRequestQueryParams(t *testing.T) {
 		t.Fatalf("unexpected merged b values: %#v", got)
 	}
 }
+
+func TestSetDefaultQueryParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/?effort=2", nil)
+	setDefaultQueryParams(r, url.Values{"effort": {"6"}, "progressive": {"true"}})
+
+	if got := r.URL.Query().Get("effort"); got != "2" {
+		t.Fatalf("effort = %q, request value should win", got)
+	}
+	if got := r.URL.Query().Get("progressive"); got != "true" {
+		t.Fatalf("progressive = %q, want default", got)
+	}
+}
@@ -1,6 +1,10 @@
 package internal
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
 
 type responseWriter struct {
 	http.ResponseWriter
@@ -15,3 +19,16 @@ func (rw *responseWriter) WriteHeader(statusCode int) {
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
 	return &responseWriter{w, http.StatusOK}
 }
+
+func writeJSONResponse(w http.ResponseWriter, response any) {
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonResponse)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const defaultMaxAnimationFrames = 100
+
+// Operations that work on all frames of an animation consistently. Requests
+// with any other operation fall back to a single (static) frame.
+var animatedOperations = map[string]bool{
+	"fit":        true,
+	"fill":       true,
+	"cover":      true,
+	"pad":        true,
+	"contain":    true,
+	"crop":       true,
+	"flop":       true,
+	"grayscale":  true,
+	"brightness": true,
+	"contrast":   true,
+	"saturation": true,
+	"gamma":      true,
+	"tint":       true,
+	"duotone":    true,
+}
+
+func isAnimationSupported(imageType vips.ImageType) bool {
+	return imageType == vips.ImageTypeGIF || imageType == vips.ImageTypeWEBP
+}
+
+func canTransformAnimation(imageOptions *ImageOptions) bool {
+	for _, operation := range imageOptions.Operations {
+		if !animatedOperations[operation] {
+			return false
+		}
+	}
+	return true
+}
+
+// imagePageCount returns the number of pages (frames) stored in the image.
+func imagePageCount(imageBytes []byte) (int, error) {
+	image, err := vips.LoadImageFromBuffer(imageBytes, vips.NewImportParams())
+	if err != nil {
+		return 0, err
+	}
+	defer image.Close()
+
+	return max(1, image.Pages()), nil
+}
+
+// animationFrameCount returns the number of frames to load, capped at maxFrames.
+func animationFrameCount(pages, maxFrames int) int {
+	if maxFrames <= 0 {
+		maxFrames = defaultMaxAnimationFrames
+	}
+
+	return max(1, min(pages, maxFrames))
+}
+
+func hasFrame(imageOptions *ImageOptions) bool {
+	return imageOptions.FrameMiddle || imageOptions.Frame != nil
+}
+
+// frameIndex returns the zero-based frame requested in imageOptions, clamped
+// to the frames available.
+func frameIndex(imageOptions *ImageOptions, pages int) int {
+	if imageOptions.FrameMiddle {
+		return pages / 2
+	}
+
+	return clampInt(*imageOptions.Frame, 0, max(0, pages-1))
+}
+
+// isAnimated reports whether the image holds more than one frame. Frames are
+// stacked vertically, each PageHeight() tall.
+func isAnimated(image *vips.ImageRef) bool {
+	return image.Height() > image.PageHeight()
+}
+
+// resizeImage resizes the image (every frame, for animations) to width x height.
+func resizeImage(image *vips.ImageRef, width, height int, size vips.Size) error {
+	if !isAnimated(image) {
+		return image.ThumbnailWithSize(width, height, vips.InterestingNone, size)
+	}
+
+	// vips_thumbnail doesn't know about frames, so scale the whole strip instead.
+	frames := image.Height() / image.PageHeight()
+	hscale := float64(width) / float64(image.Width())
+	vscale := float64(height*frames) / float64(image.Height())
+
+	if err := image.ResizeWithVScale(hscale, vscale, vips.KernelLanczos3); err != nil {
+		return err
+	}
+
+	return image.SetPageHeight(height)
+}
+
+// extractFirstFrame turns an animation into a static image.
+func extractFirstFrame(image *vips.ImageRef) error {
+	pageHeight := image.PageHeight()
+
+	if err := image.SetPageHeight(image.Height()); err != nil {
+		return err
+	}
+
+	return image.ExtractArea(0, 0, image.Width(), pageHeight)
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func makeAnimatedGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.Black, color.White, color.RGBA{R: 255, A: 255}}
+	animation := &gif.GIF{}
+
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				frame.SetColorIndex(x, y, uint8(i%len(palette)))
+			}
+		}
+
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatalf("gif.EncodeAll(): %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTransformImageKeepsAnimationFrames(t *testing.T) {
+	opts := &ImageOptions{
+		Operations: []string{"fit"},
+		Width:      20,
+		Height:     20,
+		Format:     vips.ImageTypeGIF,
+		Animated:   true,
+	}
+
+	out, err := TransformImage(makeAnimatedGIF(t, 40, 20, 3), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("gif.DecodeAll(): %v", err)
+	}
+
+	if len(decoded.Image) != 3 {
+		t.Fatalf("frames = %d, want 3", len(decoded.Image))
+	}
+	if bounds := decoded.Image[0].Bounds(); bounds.Dx() != 20 || bounds.Dy() != 10 {
+		t.Fatalf("frame size = %dx%d, want 20x10", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestTransformImageLimitsAnimationFrames(t *testing.T) {
+	opts := &ImageOptions{
+		Operations: []string{"fit"},
+		Width:      20,
+		Height:     20,
+		Format:     vips.ImageTypeGIF,
+		Animated:   true,
+		MaxFrames:  2,
+	}
+
+	out, err := TransformImage(makeAnimatedGIF(t, 20, 20, 5), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("gif.DecodeAll(): %v", err)
+	}
+
+	if len(decoded.Image) != 2 {
+		t.Fatalf("frames = %d, want 2", len(decoded.Image))
+	}
+}
+
+func TestTransformImageAnimationToStaticFormat(t *testing.T) {
+	opts := &ImageOptions{
+		Operations: []string{"fit"},
+		Width:      20,
+		Height:     20,
+		Format:     vips.ImageTypePNG,
+		Animated:   true,
+	}
+
+	out, err := TransformImage(makeAnimatedGIF(t, 20, 20, 3), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("image.Decode(): %v", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 20 || bounds.Dy() != 20 {
+		t.Fatalf("size = %dx%d, want the first frame only (20x20)", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestCanTransformAnimation(t *testing.T) {
+	if !canTransformAnimation(&ImageOptions{Operations: []string{"fit", "grayscale"}}) {
+		t.Fatalf("fit+grayscale should keep the animation")
+	}
+	if canTransformAnimation(&ImageOptions{Operations: []string{"fit", "rotate"}}) {
+		t.Fatalf("rotate should not keep the animation")
+	}
+}
+
+func TestTransformImageExtractsFrame(t *testing.T) {
+	source := makeAnimatedGIF(t, 10, 10, 3)
+	last := 10
+
+	tests := []struct {
+		name string
+		opts *ImageOptions
+		want color.RGBA
+	}{
+		{"middle", &ImageOptions{FrameMiddle: true}, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{"clamped", &ImageOptions{Frame: &last}, color.RGBA{R: 255, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Operations = []string{"fit"}
+			tt.opts.Format = vips.ImageTypePNG
+
+			out, err := TransformImage(source, tt.opts)
+			if err != nil {
+				t.Fatalf("TransformImage() error: %v", err)
+			}
+
+			if got := decodePixel(t, out.Bytes, 5, 5); got != tt.want {
+				t.Fatalf("pixel = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
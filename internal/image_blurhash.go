@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBlurHashComponentsX = 4
+	defaultBlurHashComponentsY = 3
+	maxBlurHashComponents      = 9
+)
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// parseBlurHashComponents parses the components param ("4x3"): the number of
+// horizontal and vertical components, 1-9 each.
+func parseBlurHashComponents(value string) (int, int, bool) {
+	x, y, found := strings.Cut(strings.ToLower(value), "x")
+	if !found {
+		return 0, 0, false
+	}
+
+	componentsX, errX := strconv.Atoi(x)
+	componentsY, errY := strconv.Atoi(y)
+	if errX != nil || errY != nil {
+		return 0, 0, false
+	}
+
+	if componentsX < 1 || componentsX > maxBlurHashComponents || componentsY < 1 || componentsY > maxBlurHashComponents {
+		return 0, 0, false
+	}
+
+	return componentsX, componentsY, true
+}
+
+// encodeBlurHash encodes RGBA pixels as a BlurHash (https://blurha.sh).
+// Transparent pixels are composited over white.
+func encodeBlurHash(pixels []byte, width, height, componentsX, componentsY int) (string, error) {
+	if componentsX < 1 || componentsX > maxBlurHashComponents || componentsY < 1 || componentsY > maxBlurHashComponents {
+		return "", fmt.Errorf("invalid blurhash components: %dx%d", componentsX, componentsY)
+	}
+	if width <= 0 || height <= 0 || len(pixels) < width*height*4 {
+		return "", fmt.Errorf("invalid pixel data for %dx%d", width, height)
+	}
+
+	linear := make([][3]float64, width*height)
+	for i := range linear {
+		alpha := float64(pixels[i*4+3]) / 255
+		for c := 0; c < 3; c++ {
+			linear[i][c] = sRGBToLinear(float64(pixels[i*4+c])*alpha + 255*(1-alpha))
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for y := 0; y < componentsY; y++ {
+		for x := 0; x < componentsX; x++ {
+			normalisation := 2.0
+			if x == 0 && y == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for j := 0; j < height; j++ {
+				basisY := math.Cos(math.Pi * float64(y) * float64(j) / float64(height))
+				for i := 0; i < width; i++ {
+					basis := normalisation * math.Cos(math.Pi*float64(x)*float64(i)/float64(width)) * basisY
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * linear[j*width+i][c]
+					}
+				}
+			}
+
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			for c := 0; c < 3; c++ {
+				actualMaximum = math.Max(actualMaximum, math.Abs(factor[c]))
+			}
+		}
+
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range ac {
+		var quantised [3]int
+		for c := 0; c < 3; c++ {
+			quantised[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(factor[c]/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+
+	return hash.String(), nil
+}
+
+func encodeBase83(value, length int) string {
+	var result strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result.WriteByte(base83Characters[digit])
+	}
+
+	return result.String()
+}
+
+func sRGBToLinear(value float64) float64 {
+	v := value / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := clampFloat(value, 0, 1)
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
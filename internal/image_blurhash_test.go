@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func solidPixels(width, height int, r, g, b, a byte) []byte {
+	pixels := make([]byte, 0, width*height*4)
+	for i := 0; i < width*height; i++ {
+		pixels = append(pixels, r, g, b, a)
+	}
+	return pixels
+}
+
+func TestParseBlurHashComponents(t *testing.T) {
+	if x, y, ok := parseBlurHashComponents("5X2"); !ok || x != 5 || y != 2 {
+		t.Fatalf("parseBlurHashComponents(5X2) = %d, %d, %v", x, y, ok)
+	}
+
+	for _, value := range []string{"", "4", "0x3", "4x10", "ax3"} {
+		if _, _, ok := parseBlurHashComponents(value); ok {
+			t.Fatalf("parseBlurHashComponents(%q) should fail", value)
+		}
+	}
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	red := solidPixels(8, 8, 255, 0, 0, 255)
+
+	// 1x1 components: size flag, max AC value and the DC (average) colour.
+	hash, err := encodeBlurHash(red, 8, 8, 1, 1)
+	if err != nil || hash != "00TI:j" {
+		t.Fatalf("encodeBlurHash(1x1) = %q, %v, want 00TI:j", hash, err)
+	}
+
+	hash, err = encodeBlurHash(red, 8, 8, 4, 3)
+	if err != nil {
+		t.Fatalf("encodeBlurHash(4x3) error: %v", err)
+	}
+	if len(hash) != 4+2*(4*3-1) || !strings.HasPrefix(hash, "L") || hash[2:6] != "TI:j" {
+		t.Fatalf("encodeBlurHash(4x3) = %q", hash)
+	}
+
+	hash, _ = encodeBlurHash(solidPixels(4, 4, 0, 0, 0, 0), 4, 4, 1, 1)
+	if white, _ := encodeBlurHash(solidPixels(4, 4, 255, 255, 255, 255), 4, 4, 1, 1); hash != white {
+		t.Fatalf("transparent hash = %q, want %q", hash, white)
+	}
+
+	if _, err := encodeBlurHash(red, 8, 8, 10, 3); err == nil {
+		t.Fatalf("encodeBlurHash() should reject 10 components")
+	}
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	minBudgetQuality      = 10
+	maxBudgetEncodes      = 12
+	budgetDownscaleFactor = 0.8
+	minBudgetDimension    = 16
+)
+
+// Formats whose output size depends on the quality setting.
+var qualityImageTypes = map[vips.ImageType]bool{
+	vips.ImageTypeJPEG: true,
+	vips.ImageTypeWEBP: true,
+	vips.ImageTypeAVIF: true,
+	vips.ImageTypeHEIF: true,
+	vips.ImageTypeJXL:  true,
+}
+
+// exportWithinBudget encodes the image at the highest quality (up to
+// imageOptions.Quality) that fits in imageOptions.MaxBytes, searching with a
+// binary search. When even the lowest quality is too large, the image is
+// downscaled and the search repeated, starting from the last quality tried (the
+// higher ones were too large already) and searching upwards if it fits. The
+// number of encodes is capped, in which case the smallest output is returned,
+// even if it's over budget. imageOptions.Quality is updated to the chosen
+// quality.
+func exportWithinBudget(image *vips.ImageRef, exportFunc ImageExport, imageOptions *ImageOptions) ([]byte, error) {
+	options := *imageOptions
+	encodes := 0
+	maxQuality := imageOptions.Quality
+	startQuality := imageOptions.Quality
+
+	encode := func(quality int) ([]byte, error) {
+		encodes++
+		options.Quality = quality
+		return exportFunc(image, &options)
+	}
+
+	var smallest []byte
+	smallestQuality := imageOptions.Quality
+
+	for {
+		fileBytes, err := encode(startQuality)
+		if err != nil {
+			return nil, err
+		}
+		fits := len(fileBytes) <= imageOptions.MaxBytes
+
+		if !qualityImageTypes[imageOptions.Format] {
+			if fits {
+				return fileBytes, nil
+			}
+		} else {
+			lower, upper := minBudgetQuality, startQuality-1
+
+			var best []byte
+			if fits {
+				best = fileBytes
+				imageOptions.Quality = startQuality
+				lower, upper = startQuality+1, maxQuality
+			}
+
+			for lower <= upper && encodes < maxBudgetEncodes {
+				quality := (lower + upper) / 2
+
+				fileBytes, err = encode(quality)
+				if err != nil {
+					return nil, err
+				}
+
+				if len(fileBytes) <= imageOptions.MaxBytes {
+					best = fileBytes
+					imageOptions.Quality = quality
+					lower = quality + 1
+				} else {
+					upper = quality - 1
+				}
+			}
+
+			if best != nil {
+				return best, nil
+			}
+		}
+
+		if smallest == nil || len(fileBytes) < len(smallest) {
+			smallest, smallestQuality = fileBytes, options.Quality
+		}
+
+		if encodes >= maxBudgetEncodes {
+			break
+		}
+
+		// Too large even at the lowest quality: try again with fewer pixels.
+		width := int(math.Round(float64(image.Width()) * budgetDownscaleFactor))
+		height := int(math.Round(float64(image.PageHeight()) * budgetDownscaleFactor))
+		if width < minBudgetDimension || height < minBudgetDimension {
+			break
+		}
+
+		if err := resizeImage(image, width, height, vips.SizeForce); err != nil {
+			return nil, err
+		}
+		startQuality = options.Quality
+	}
+
+	imageOptions.Quality = smallestQuality
+	return smallest, nil
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func makeNoisePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	random := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(random.Intn(256)), G: uint8(random.Intn(256)), B: uint8(random.Intn(256)), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode(): %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTransformImageMaxBytesLowersQuality(t *testing.T) {
+	src := makeNoisePNG(t, 200, 200)
+
+	unbounded, err := TransformImage(src, &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, Quality: 90})
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	budget := unbounded.Size * 2 / 3
+	out, err := TransformImage(src, &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, Quality: 90, MaxBytes: budget})
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if out.Size > budget {
+		t.Fatalf("size = %d, want at most %d", out.Size, budget)
+	}
+	if out.Quality >= 90 || out.Quality < minBudgetQuality {
+		t.Fatalf("quality = %d, want between %d and 90", out.Quality, minBudgetQuality)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("image.Decode(): %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 200 {
+		t.Fatalf("width = %d, quality alone should have been enough", bounds.Dx())
+	}
+}
+
+func TestTransformImageMaxBytesDownscales(t *testing.T) {
+	src := makeNoisePNG(t, 200, 200)
+
+	out, err := TransformImage(src, &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, Quality: 80, MaxBytes: 40000})
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if out.Size > 40000 {
+		t.Fatalf("size = %d, want at most 40000", out.Size)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("image.Decode(): %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() >= 200 {
+		t.Fatalf("width = %d, want the image downscaled", bounds.Dx())
+	}
+}
+
+func TestExportWithinBudgetKeepsQualityBoundsAfterDownscale(t *testing.T) {
+	image := loadTestImage(t, makePNG(t, 100, 100))
+
+	// Output size is proportional to width and quality: nothing fits at
+	// 100 px, qualities up to 11 fit at 80 px.
+	encodes := 0
+	exportFunc := func(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+		encodes++
+		return make([]byte, image.Width()*imageOptions.Quality), nil
+	}
+
+	opts := &ImageOptions{Format: vips.ImageTypeJPEG, Quality: 80, MaxBytes: 900}
+	out, err := exportWithinBudget(image, exportFunc, opts)
+	if err != nil {
+		t.Fatalf("exportWithinBudget() error: %v", err)
+	}
+
+	if encodes != maxBudgetEncodes {
+		t.Fatalf("encodes = %d, want the cap (%d) reached", encodes, maxBudgetEncodes)
+	}
+	if len(out) > opts.MaxBytes {
+		t.Fatalf("size = %d, want at most %d", len(out), opts.MaxBytes)
+	}
+	if image.Width() != 80 || opts.Quality != len(out)/80 {
+		t.Fatalf("width/quality = %d/%d", image.Width(), opts.Quality)
+	}
+}
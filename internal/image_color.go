@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const defaultBackground = "ffffff"
+
+// parseHexColor parses "rgb", "rrggbb" or "rrggbbaa" (with an optional "#" prefix).
+func parseHexColor(value string) (vips.ColorRGBA, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+
+	if len(value) == 6 {
+		value += "ff"
+	}
+
+	if len(value) != 8 {
+		return vips.ColorRGBA{}, false
+	}
+
+	rgba, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return vips.ColorRGBA{}, false
+	}
+
+	return vips.ColorRGBA{
+		R: uint8(rgba >> 24),
+		G: uint8(rgba >> 16),
+		B: uint8(rgba >> 8),
+		A: uint8(rgba),
+	}, true
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Values of the icc param.
+const (
+	iccKeep  = "keep"
+	iccSRGB  = "srgb"
+	iccStrip = "strip"
+)
+
+func isValidICC(value string) bool {
+	return value == iccKeep || value == iccSRGB || value == iccStrip
+}
+
+// applyColorProfile handles the embedded ICC profile according to
+// imageOptions.ICC. CMYK images are always converted to sRGB, as browsers
+// (and most output formats) can't display them.
+func applyColorProfile(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if image.Interpretation() == vips.InterpretationCMYK {
+		if err := convertToSRGB(image); err != nil {
+			return err
+		}
+	}
+
+	switch imageOptions.ICC {
+	case iccKeep:
+		return nil
+	case iccStrip:
+		// Explicit request: drop the profile without converting the pixels.
+		return image.RemoveICCProfile()
+	default:
+		return convertToSRGB(image)
+	}
+}
+
+// convertToSRGB converts wide-gamut (Display P3, Adobe RGB) and CMYK images to
+// sRGB, so that they look the same once the profile is stripped.
+func convertToSRGB(image *vips.ImageRef) error {
+	if image.Interpretation() == vips.InterpretationCMYK {
+		// Uses the embedded CMYK profile, or a generic one when there's none.
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return err
+		}
+
+		// The CMYK profile doesn't describe the converted pixels anymore.
+		return image.RemoveICCProfile()
+	}
+
+	if !image.HasICCProfile() {
+		// Untagged images are assumed to be sRGB already.
+		return nil
+	}
+
+	return image.TransformICCProfile(vips.SRGBIEC6196621ICCProfilePath)
+}
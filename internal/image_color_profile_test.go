@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func loadTestImage(t *testing.T, data []byte) *vips.ImageRef {
+	t.Helper()
+
+	img, err := vips.LoadImageFromBuffer(data, vips.NewImportParams())
+	if err != nil {
+		t.Fatalf("LoadImageFromBuffer(): %v", err)
+	}
+	t.Cleanup(img.Close)
+
+	return img
+}
+
+// makeTaggedPNG returns a PNG with an embedded sRGB ICC profile.
+func makeTaggedPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := loadTestImage(t, makePNG(t, 20, 20))
+	if err := img.TransformICCProfile(vips.SRGBIEC6196621ICCProfilePath); err != nil {
+		t.Fatalf("TransformICCProfile(): %v", err)
+	}
+
+	out, _, err := img.ExportPng(vips.NewPngExportParams())
+	if err != nil {
+		t.Fatalf("ExportPng(): %v", err)
+	}
+
+	return out
+}
+
+func TestTransformImageICC(t *testing.T) {
+	src := makeTaggedPNG(t)
+	if !loadTestImage(t, src).HasICCProfile() {
+		t.Fatalf("test image should have an ICC profile")
+	}
+
+	cases := []struct {
+		icc     string
+		strip   bool
+		wantICC bool
+	}{
+		{iccKeep, true, true},
+		{iccKeep, false, true},
+		{iccSRGB, true, false},
+		{iccStrip, false, false},
+	}
+
+	for _, tc := range cases {
+		opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, StripMetadata: tc.strip, ICC: tc.icc}
+
+		out, err := TransformImage(src, opts)
+		if err != nil {
+			t.Fatalf("TransformImage(icc=%s) error: %v", tc.icc, err)
+		}
+
+		if got := loadTestImage(t, out.Bytes).HasICCProfile(); got != tc.wantICC {
+			t.Fatalf("icc=%s strip=%v: has profile = %v, want %v", tc.icc, tc.strip, got, tc.wantICC)
+		}
+	}
+}
+
+func TestTransformImageConvertsCMYK(t *testing.T) {
+	img := loadTestImage(t, makePNG(t, 20, 20))
+	if err := img.ToColorSpace(vips.InterpretationCMYK); err != nil {
+		t.Skipf("CMYK conversion not available: %v", err)
+	}
+
+	cmyk, _, err := img.ExportJpeg(vips.NewJpegExportParams())
+	if err != nil {
+		t.Fatalf("ExportJpeg(): %v", err)
+	}
+
+	opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, StripMetadata: true, ICC: iccKeep}
+	out, err := TransformImage(cmyk, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if got := loadTestImage(t, out.Bytes).Interpretation(); got != vips.InterpretationSRGB {
+		t.Fatalf("interpretation = %v, want sRGB", got)
+	}
+
+	got := decodePixel(t, out.Bytes, 10, 10)
+	if absDiff(got.R, 200) > 30 || absDiff(got.G, 100) > 30 || absDiff(got.B, 50) > 30 {
+		t.Fatalf("pixel = %+v, want close to (200, 100, 50)", got)
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestParseHexColor(t *testing.T) {
+	cases := map[string]vips.ColorRGBA{
+		"ffffff":    {R: 255, G: 255, B: 255, A: 255},
+		"#ff0000":   {R: 255, A: 255},
+		"0f0":       {G: 255, A: 255},
+		"00000080":  {A: 128},
+		"#12345678": {R: 0x12, G: 0x34, B: 0x56, A: 0x78},
+	}
+
+	for input, want := range cases {
+		got, ok := parseHexColor(input)
+		if !ok || got != want {
+			t.Fatalf("parseHexColor(%q) = %v, %v, want %v", input, got, ok, want)
+		}
+	}
+
+	for _, input := range []string{"", "ff", "gggggg", "1234567"} {
+		if _, ok := parseHexColor(input); ok {
+			t.Fatalf("parseHexColor(%q) should fail", input)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
+	"image/png"
 
 	"github.com/davidbyttow/govips/v2/vips"
+	"golang.org/x/image/bmp"
 )
 
 var ImageExportMap = map[vips.ImageType]ImageExport{
@@ -13,13 +16,50 @@ var ImageExportMap = map[vips.ImageType]ImageExport{
 	vips.ImageTypeGIF:  ExportGIF,
 	vips.ImageTypeHEIF: ExportHEIF,
 	vips.ImageTypeAVIF: ExportAVIF,
+	vips.ImageTypeJXL:  ExportJXL,
+	vips.ImageTypeTIFF: ExportTIFF,
+	vips.ImageTypeBMP:  ExportBMP,
 }
 
+// Formats only returned when requested explicitly with format=, and never
+// inherited from the source image, as browsers can't display them.
+var explicitOnlyExportFormats = map[vips.ImageType]bool{
+	vips.ImageTypeTIFF: true,
+	vips.ImageTypeBMP:  true,
+}
+
+// Bounds of the q=auto quality search.
+const (
+	defaultAutoQualityTarget = 0.98
+	minAutoQuality           = 30
+	maxAutoQuality           = 95
+	maxAutoQualityEncodes    = 6
+	ssimWindow               = 8
+)
+
+// Effort ranges accepted by the encoders.
+const (
+	maxWebPEffort = 6
+	maxAVIFEffort = 9
+	minJXLEffort  = 1
+	maxJXLEffort  = 9
+	maxGIFEffort  = 10
+)
+
 type ImageExport func(*vips.ImageRef, *ImageOptions) ([]byte, error)
 
 func IsImageExportSupported(imageType vips.ImageType) bool {
 	_, exists := ImageExportMap[imageType]
-	return exists
+	if !exists {
+		return false
+	}
+
+	// BMP is encoded here, rather than by libvips.
+	if imageType == vips.ImageTypeBMP {
+		return true
+	}
+
+	return vips.IsTypeSupported(imageType)
 }
 
 func ExportImage(image *vips.ImageRef, imageOptions *ImageOptions) (*ProcessedImage, error) {
@@ -27,39 +67,255 @@ func ExportImage(image *vips.ImageRef, imageOptions *ImageOptions) (*ProcessedIm
 		image.AutoRotate()
 	}
 
+	if err := applyMetadataPolicy(image, imageOptions); err != nil {
+		return nil, err
+	}
+
+	// Stripping on export drops the ICC profile too: the rest of the metadata
+	// is already gone, so skip it to let icc=keep survive meta=none.
+	if imageOptions.StripMetadata && imageOptions.ICC == iccKeep && image.HasICCProfile() {
+		imageOptions.StripMetadata = false
+	}
+
 	if imageOptions.Format == vips.ImageTypeUnknown {
 		imageOptions.Format = vips.ImageTypeJPEG
 	}
 
+	// Transparency was added on purpose (e.g. rounded corners) or comes from the
+	// source with format=auto: unless JPEG was explicitly requested (in which
+	// case ExportJPEG flattens to the background), switch to a format that can
+	// store it.
+	keepAlpha := imageOptions.KeepAlpha || (imageOptions.RequestedFormat == formatAuto && image.HasAlpha())
+	if keepAlpha && imageOptions.Format == vips.ImageTypeJPEG && ImageType(imageOptions.RequestedFormat) != vips.ImageTypeJPEG {
+		imageOptions.Format = vips.ImageTypePNG
+	}
+
 	exportFunc, exists := ImageExportMap[imageOptions.Format]
 	if !exists {
 		return nil, fmt.Errorf("format not supported: %s", fmt.Sprint(imageOptions.Format))
 	}
+	if imageOptions.ICO {
+		exportFunc = ExportICO
+	}
+
+	// Formats without animation support would otherwise get all frames
+	// stacked on top of each other.
+	if isAnimated(image) && !isAnimationSupported(imageOptions.Format) {
+		if err := extractFirstFrame(image); err != nil {
+			return nil, err
+		}
+	}
 
-	fileBytes, err := exportFunc(image, imageOptions)
+	var fileBytes []byte
+	var err error
+
+	autoQuality := imageOptions.AutoQuality && qualityImageTypes[imageOptions.Format] && !isAnimated(image)
+	if autoQuality {
+		fileBytes, err = exportWithAutoQuality(image, exportFunc, imageOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if imageOptions.MaxBytes > 0 && (fileBytes == nil || len(fileBytes) > imageOptions.MaxBytes) {
+		fileBytes, err = exportWithinBudget(image, exportFunc, imageOptions)
+	} else if fileBytes == nil {
+		fileBytes, err = exportFunc(image, imageOptions)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	mime := MimeTypeFromImageType(vips.DetermineImageType(fileBytes))
+	exportedImageType := vips.DetermineImageType(fileBytes)
+	if exportedImageType == vips.ImageTypeUnknown {
+		exportedImageType = imageOptions.Format
+	}
+
+	mime := MimeTypeFromImageType(exportedImageType)
+	if imageOptions.ICO {
+		mime = mimeTypeICO
+	}
 
 	return &ProcessedImage{
-		Bytes: fileBytes,
-		Mime:  mime,
-		Size:  len(fileBytes),
+		Bytes:   fileBytes,
+		Mime:    mime,
+		Size:    len(fileBytes),
+		Width:   image.Width(),
+		Height:  image.PageHeight(),
+		Quality: imageOptions.Quality,
 	}, nil
 }
 
+// exportWithAutoQuality encodes the image at the lowest quality whose output
+// keeps an SSIM of at least imageOptions.AutoQualityTarget compared to the
+// image itself, using a binary search bounded to maxAutoQualityEncodes encodes.
+// imageOptions.Quality is updated to the chosen quality.
+func exportWithAutoQuality(image *vips.ImageRef, exportFunc ImageExport, imageOptions *ImageOptions) ([]byte, error) {
+	target := imageOptions.AutoQualityTarget
+	if target <= 0 || target >= 1 {
+		target = defaultAutoQualityTarget
+	}
+
+	reference, err := luminance(image, imageOptions.Background)
+	if err != nil {
+		return nil, err
+	}
+
+	options := *imageOptions
+	lower, upper := minAutoQuality, maxAutoQuality
+
+	var best []byte
+	bestQuality := maxAutoQuality
+
+	for encodes := 0; lower <= upper && encodes < maxAutoQualityEncodes; encodes++ {
+		options.Quality = (lower + upper) / 2
+
+		fileBytes, err := exportFunc(image, &options)
+		if err != nil {
+			return nil, err
+		}
+
+		similarity, err := encodedSimilarity(fileBytes, reference, image.Width(), image.Height(), imageOptions.Background)
+		if err != nil {
+			return nil, err
+		}
+
+		if similarity >= target {
+			best, bestQuality = fileBytes, options.Quality
+			upper = options.Quality - 1
+		} else {
+			lower = options.Quality + 1
+		}
+	}
+
+	imageOptions.Quality = bestQuality
+	if best == nil {
+		options.Quality = bestQuality
+		return exportFunc(image, &options)
+	}
+
+	return best, nil
+}
+
+// encodedSimilarity decodes fileBytes and returns its SSIM against the
+// reference luminance.
+func encodedSimilarity(fileBytes, reference []byte, width, height int, background vips.ColorRGBA) (float64, error) {
+	decoded, err := vips.LoadImageFromBuffer(fileBytes, vips.NewImportParams())
+	if err != nil {
+		return 0, err
+	}
+	defer decoded.Close()
+
+	if decoded.Width() != width || decoded.Height() != height {
+		return 0, fmt.Errorf("encoded image size mismatch: %dx%d", decoded.Width(), decoded.Height())
+	}
+
+	pixels, err := luminance(decoded, background)
+	if err != nil {
+		return 0, err
+	}
+
+	return ssim(reference, pixels, width, height), nil
+}
+
+// luminance returns the 8-bit greyscale pixels of a copy of the image.
+// Transparency is flattened against the background, like ExportJPEG does.
+func luminance(image *vips.ImageRef, background vips.ColorRGBA) ([]byte, error) {
+	grey, err := image.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer grey.Close()
+
+	if grey.HasAlpha() {
+		if err := grey.Flatten(&vips.Color{R: background.R, G: background.G, B: background.B}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := grey.ToColorSpace(vips.InterpretationBW); err != nil {
+		return nil, err
+	}
+
+	if err := grey.Cast(vips.BandFormatUchar); err != nil {
+		return nil, err
+	}
+
+	return grey.ToBytes()
+}
+
+// ssim returns the mean structural similarity (1 = identical) of two greyscale
+// images, computed over non-overlapping ssimWindow x ssimWindow blocks.
+func ssim(a, b []byte, width, height int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+		n  = ssimWindow * ssimWindow
+	)
+
+	if len(a) < width*height || len(b) < width*height {
+		return 0
+	}
+
+	var total float64
+	var windows int
+
+	for y := 0; y+ssimWindow <= height; y += ssimWindow {
+		for x := 0; x+ssimWindow <= width; x += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+
+			for wy := y; wy < y+ssimWindow; wy++ {
+				for wx := x; wx < x+ssimWindow; wx++ {
+					pa, pb := float64(a[wy*width+wx]), float64(b[wy*width+wx])
+					sumA += pa
+					sumB += pb
+					sumAA += pa * pa
+					sumBB += pb * pb
+					sumAB += pa * pb
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varianceA := sumAA/n - meanA*meanA
+			varianceB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varianceA + varianceB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+
+	return total / float64(windows)
+}
+
 func ExportJPEG(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	// JPEG has no alpha channel: flatten against the requested background,
+	// rather than letting libvips pick black.
+	if image.HasAlpha() {
+		background := &vips.Color{R: imageOptions.Background.R, G: imageOptions.Background.G, B: imageOptions.Background.B}
+		if err := image.Flatten(background); err != nil {
+			return nil, err
+		}
+	}
+
 	ep := vips.NewJpegExportParams()
 	ep.StripMetadata = imageOptions.StripMetadata
 	ep.Quality = imageOptions.Quality
 	ep.OptimizeCoding = true
-	ep.SubsampleMode = vips.VipsForeignSubsampleAuto
+	ep.SubsampleMode = jpegSubsampleMode(imageOptions.Chroma)
 	ep.TrellisQuant = true
 	ep.OvershootDeringing = true
 	ep.OptimizeScans = true
 	ep.QuantTable = 3
+	if imageOptions.Progressive != nil {
+		ep.Interlace = *imageOptions.Progressive
+		ep.OptimizeScans = *imageOptions.Progressive
+	}
 
 	fileBytes, _, err := image.ExportJpeg(ep)
 
@@ -70,10 +326,32 @@ func ExportJPEG(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error
 	return fileBytes, nil
 }
 
+func jpegSubsampleMode(chroma string) vips.SubsampleMode {
+	switch chroma {
+	case chroma420:
+		return vips.VipsForeignSubsampleOn
+	case chroma444:
+		return vips.VipsForeignSubsampleOff
+	default:
+		return vips.VipsForeignSubsampleAuto
+	}
+}
+
 func ExportPNG(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
 	ep := vips.NewPngExportParams()
 	ep.StripMetadata = imageOptions.StripMetadata
 	ep.Quality = imageOptions.Quality
+	ep.Palette = imageOptions.Palette
+	// Without a palette, libvips saves bit depths below 8 as greyscale.
+	if ep.Palette && imageOptions.PaletteDepth > 0 {
+		ep.Bitdepth = imageOptions.PaletteDepth
+	}
+	if imageOptions.Progressive != nil {
+		ep.Interlace = *imageOptions.Progressive
+	}
+	if imageOptions.Compression != nil {
+		ep.Compression = *imageOptions.Compression
+	}
 
 	fileBytes, _, err := image.ExportPng(ep)
 
@@ -88,6 +366,11 @@ func ExportWEBP(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error
 	ep := vips.NewWebpExportParams()
 	ep.StripMetadata = imageOptions.StripMetadata
 	ep.Quality = imageOptions.Quality
+	ep.Lossless = imageOptions.Lossless || imageOptions.NearLossless
+	ep.NearLossless = imageOptions.NearLossless
+	if imageOptions.Effort > 0 {
+		ep.ReductionEffort = min(imageOptions.Effort, maxWebPEffort)
+	}
 
 	fileBytes, _, err := image.ExportWebp(ep)
 
@@ -102,6 +385,9 @@ func ExportGIF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error)
 	ep := vips.NewGifExportParams()
 	ep.StripMetadata = imageOptions.StripMetadata
 	ep.Quality = imageOptions.Quality
+	if imageOptions.Effort > 0 {
+		ep.Effort = min(imageOptions.Effort, maxGIFEffort)
+	}
 
 	fileBytes, _, err := image.ExportGIF(ep)
 
@@ -113,8 +399,19 @@ func ExportGIF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error)
 }
 
 func ExportHEIF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	if err := stripICCProfile(image, imageOptions); err != nil {
+		return nil, err
+	}
+
 	ep := vips.NewHeifExportParams()
 	ep.Quality = imageOptions.Quality
+	ep.Lossless = imageOptions.Lossless
+	if imageOptions.Effort > 0 {
+		ep.Effort = min(imageOptions.Effort, maxAVIFEffort)
+	}
+	if imageOptions.BitDepth > 0 {
+		ep.Bitdepth = imageOptions.BitDepth
+	}
 
 	fileBytes, _, err := image.ExportHeif(ep)
 	if err != nil {
@@ -128,6 +425,13 @@ func ExportAVIF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error
 	ep := vips.NewAvifExportParams()
 	ep.StripMetadata = imageOptions.StripMetadata
 	ep.Quality = imageOptions.Quality
+	ep.Lossless = imageOptions.Lossless
+	if imageOptions.Effort > 0 {
+		ep.Effort = min(imageOptions.Effort, maxAVIFEffort)
+	}
+	if imageOptions.BitDepth > 0 {
+		ep.Bitdepth = imageOptions.BitDepth
+	}
 
 	fileBytes, _, err := image.ExportAvif(ep)
 	if err != nil {
@@ -136,3 +440,82 @@ func ExportAVIF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error
 
 	return fileBytes, nil
 }
+
+func ExportJXL(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	if err := stripICCProfile(image, imageOptions); err != nil {
+		return nil, err
+	}
+
+	ep := vips.NewJxlExportParams()
+	ep.Quality = imageOptions.Quality
+	ep.Lossless = imageOptions.Lossless
+	if imageOptions.Effort > 0 {
+		ep.Effort = clampInt(imageOptions.Effort, minJXLEffort, maxJXLEffort)
+	}
+
+	fileBytes, _, err := image.ExportJxl(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileBytes, nil
+}
+
+// stripICCProfile removes the ICC profile for encoders without a strip
+// option; applyMetadataPolicy has already removed the other metadata.
+func stripICCProfile(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if !imageOptions.StripMetadata || !image.HasICCProfile() {
+		return nil
+	}
+
+	return image.RemoveICCProfile()
+}
+
+func ExportTIFF(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	ep := vips.NewTiffExportParams()
+	ep.StripMetadata = imageOptions.StripMetadata
+	ep.Quality = imageOptions.Quality
+
+	fileBytes, _, err := image.ExportTiff(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileBytes, nil
+}
+
+// ExportBMP goes through PNG, as libvips can only write BMP files when built
+// with ImageMagick.
+func ExportBMP(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	if err := ensureColorImage(image); err != nil {
+		return nil, err
+	}
+
+	// The BMP encoder only keeps transparency for 8-bit images.
+	if image.BandFormat() != vips.BandFormatUchar {
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return nil, err
+		}
+	}
+
+	ep := vips.NewPngExportParams()
+	ep.StripMetadata = true
+	ep.Compression = 0
+
+	pngBytes, _, err := image.ExportPng(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(pngBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, decoded); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const defaultGravity = "center"
+
+// Focal points (relative x, y) for each supported gravity name.
+var gravityFocalPoints = map[string][2]float64{
+	"center":     {0.5, 0.5},
+	"centre":     {0.5, 0.5},
+	"north":      {0.5, 0},
+	"south":      {0.5, 1},
+	"east":       {1, 0.5},
+	"west":       {0, 0.5},
+	"north-east": {1, 0},
+	"north-west": {0, 0},
+	"south-east": {1, 1},
+	"south-west": {0, 1},
+}
+
+func isValidGravity(name string) bool {
+	_, exists := gravityFocalPoints[strings.ToLower(name)]
+	return exists
+}
+
+func focalPointForGravity(name string) (float64, float64) {
+	point, exists := gravityFocalPoints[strings.ToLower(name)]
+	if !exists {
+		point = gravityFocalPoints[defaultGravity]
+	}
+
+	return point[0], point[1]
+}
+
+// parseFocalPoint parses "x,y", where both values are fractions of the image size (0-1).
+func parseFocalPoint(value string) (float64, float64, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	x, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || math.IsNaN(x) {
+		return 0, 0, false
+	}
+
+	y, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || math.IsNaN(y) {
+		return 0, 0, false
+	}
+
+	return clampFloat(x, 0, 1), clampFloat(y, 0, 1), true
+}
+
+// cropOffset returns the offset of a window of cropSize within size, centered on
+// focal (relative position) as closely as the bounds allow.
+func cropOffset(size, cropSize int, focal float64) int {
+	offset := int(math.Round(focal*float64(size) - float64(cropSize)/2))
+	return clampInt(offset, 0, size-cropSize)
+}
+
+func clampFloat(value, lower, upper float64) float64 {
+	return math.Max(lower, math.Min(upper, value))
+}
+
+func clampInt(value, lower, upper int) int {
+	if value < lower {
+		return lower
+	}
+	if value > upper {
+		return upper
+	}
+	return value
+}
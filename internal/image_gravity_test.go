@@ -0,0 +1,50 @@
+package internal
+
+import "testing"
+
+func TestFocalPointForGravity(t *testing.T) {
+	cases := map[string][2]float64{
+		"center":     {0.5, 0.5},
+		"North":      {0.5, 0},
+		"south-east": {1, 1},
+		"west":       {0, 0.5},
+		"unknown":    {0.5, 0.5},
+	}
+
+	for name, want := range cases {
+		x, y := focalPointForGravity(name)
+		if x != want[0] || y != want[1] {
+			t.Fatalf("focalPointForGravity(%q) = %v,%v, want %v,%v", name, x, y, want[0], want[1])
+		}
+	}
+}
+
+func TestParseFocalPoint(t *testing.T) {
+	x, y, ok := parseFocalPoint("0.3,0.7")
+	if !ok || x != 0.3 || y != 0.7 {
+		t.Fatalf("parseFocalPoint() = %v,%v,%v", x, y, ok)
+	}
+
+	x, y, ok = parseFocalPoint("-1, 2")
+	if !ok || x != 0 || y != 1 {
+		t.Fatalf("parseFocalPoint() should clamp, got %v,%v,%v", x, y, ok)
+	}
+
+	for _, value := range []string{"", "0.5", "a,b", "0.1,0.2,0.3"} {
+		if _, _, ok := parseFocalPoint(value); ok {
+			t.Fatalf("parseFocalPoint(%q) should fail", value)
+		}
+	}
+}
+
+func TestCropOffset(t *testing.T) {
+	if got := cropOffset(100, 20, 0.5); got != 40 {
+		t.Fatalf("cropOffset(center) = %d, want 40", got)
+	}
+	if got := cropOffset(100, 20, 0); got != 0 {
+		t.Fatalf("cropOffset(start) = %d, want 0", got)
+	}
+	if got := cropOffset(100, 20, 1); got != 80 {
+		t.Fatalf("cropOffset(end) = %d, want 80", got)
+	}
+}
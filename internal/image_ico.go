@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// libvips can't write ICO files: favicons are assembled from PNG-encoded
+// entries instead (supported since Windows Vista and by all current browsers).
+const mimeTypeICO = "image/x-icon"
+
+// Icon sizes written to ICO files, in pixels.
+var icoSizes = []int{16, 32, 48}
+
+type icoEntry struct {
+	size int
+	png  []byte
+}
+
+// ExportICO writes a multi-size favicon. The image is fitted within each square
+// icon size and centered on a transparent canvas.
+func ExportICO(image *vips.ImageRef, imageOptions *ImageOptions) ([]byte, error) {
+	entries := make([]icoEntry, 0, len(icoSizes))
+
+	for _, size := range icoSizes {
+		pngBytes, err := exportICOEntry(image, size)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, icoEntry{size: size, png: pngBytes})
+	}
+
+	return encodeICO(entries), nil
+}
+
+func exportICOEntry(image *vips.ImageRef, size int) ([]byte, error) {
+	icon, err := image.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer icon.Close()
+
+	if err := icon.ThumbnailWithSize(size, size, vips.InterestingNone, vips.SizeBoth); err != nil {
+		return nil, err
+	}
+
+	if err := ensureColorImage(icon); err != nil {
+		return nil, err
+	}
+
+	if err := icon.AddAlpha(); err != nil {
+		return nil, err
+	}
+
+	left := (size - icon.Width()) / 2
+	top := (size - icon.Height()) / 2
+	if err := icon.EmbedBackgroundRGBA(left, top, size, size, &vips.ColorRGBA{}); err != nil {
+		return nil, err
+	}
+
+	ep := vips.NewPngExportParams()
+	ep.StripMetadata = true
+
+	pngBytes, _, err := icon.ExportPng(ep)
+	return pngBytes, err
+}
+
+// encodeICO writes the ICONDIR header, followed by one ICONDIRENTRY per icon
+// and the icon data.
+func encodeICO(entries []icoEntry) []byte {
+	const (
+		headerSize = 6
+		entrySize  = 16
+	)
+
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(entries))})
+
+	offset := headerSize + entrySize*len(entries)
+	for _, entry := range entries {
+		// Sizes are stored in a single byte, where 0 means 256.
+		dimension := uint8(entry.size % 256)
+
+		binary.Write(&buf, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dimension, dimension, 0, 0, 1, 32, uint32(len(entry.png)), uint32(offset)})
+
+		offset += len(entry.png)
+	}
+
+	for _, entry := range entries {
+		buf.Write(entry.png)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestTransformImageICO(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, ICO: true}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if out.Mime != "image/x-icon" {
+		t.Fatalf("mime = %q, want image/x-icon", out.Mime)
+	}
+
+	var header [3]uint16
+	if err := binary.Read(bytes.NewReader(out.Bytes), binary.LittleEndian, &header); err != nil {
+		t.Fatalf("binary.Read(): %v", err)
+	}
+	if header[1] != 1 || int(header[2]) != len(icoSizes) {
+		t.Fatalf("ICONDIR = %v", header)
+	}
+
+	for i, size := range icoSizes {
+		entry := out.Bytes[6+16*i:]
+		length := binary.LittleEndian.Uint32(entry[8:12])
+		offset := binary.LittleEndian.Uint32(entry[12:16])
+
+		if int(entry[0]) != size || int(entry[1]) != size {
+			t.Fatalf("entry %d size = %dx%d, want %d", i, entry[0], entry[1], size)
+		}
+
+		icon, err := png.Decode(bytes.NewReader(out.Bytes[offset : offset+length]))
+		if err != nil {
+			t.Fatalf("entry %d: png.Decode(): %v", i, err)
+		}
+		if bounds := icon.Bounds(); bounds.Dx() != size || bounds.Dy() != size {
+			t.Fatalf("entry %d image = %dx%d, want %d", i, bounds.Dx(), bounds.Dy(), size)
+		}
+		if _, _, _, a := icon.At(0, 0).RGBA(); a != 0 {
+			t.Fatalf("entry %d: padding should be transparent", i)
+		}
+	}
+}
+
+func TestTransformImageBMPAndTIFF(t *testing.T) {
+	cases := map[vips.ImageType]string{
+		vips.ImageTypeBMP:  "image/bmp",
+		vips.ImageTypeTIFF: "image/tiff",
+	}
+
+	for imageType, mime := range cases {
+		opts := &ImageOptions{Operations: []string{"fit"}, Width: 10, Format: imageType, Quality: 80}
+
+		out, err := TransformImage(makePNG(t, 40, 20), opts)
+		if err != nil {
+			t.Fatalf("TransformImage(%v) error: %v", imageType, err)
+		}
+
+		if out.Mime != mime {
+			t.Fatalf("mime = %q, want %q", out.Mime, mime)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// ImageInfo describes a source image, as returned by the /image/info endpoint.
+type ImageInfo struct {
+	Format string `json:"format"`
+	Mime   string `json:"mime"`
+	// Width and Height are the stored dimensions (of a single page);
+	// OrientedWidth and OrientedHeight are the dimensions after applying the
+	// EXIF orientation, i.e. as displayed.
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	OrientedWidth  int               `json:"orientedWidth"`
+	OrientedHeight int               `json:"orientedHeight"`
+	Orientation    int               `json:"orientation"`
+	Pages          int               `json:"pages"`
+	Frames         int               `json:"frames"`
+	HasAlpha       bool              `json:"hasAlpha"`
+	ColorSpace     string            `json:"colorSpace"`
+	HasICCProfile  bool              `json:"hasIccProfile"`
+	Size           int               `json:"size"`
+	Exif           map[string]string `json:"exif,omitempty"`
+}
+
+var colorSpaceNames = map[vips.Interpretation]string{
+	vips.InterpretationBW:        "b-w",
+	vips.InterpretationGrey16:    "grey16",
+	vips.InterpretationSRGB:      "srgb",
+	vips.InterpretationRGB:       "rgb",
+	vips.InterpretationRGB16:     "rgb16",
+	vips.InterpretationScRGB:     "scrgb",
+	vips.InterpretationCMYK:      "cmyk",
+	vips.InterpretationLAB:       "lab",
+	vips.InterpretationLABS:      "labs",
+	vips.InterpretationLCH:       "lch",
+	vips.InterpretationXYZ:       "xyz",
+	vips.InterpretationHSV:       "hsv",
+	vips.InterpretationMultiband: "multiband",
+}
+
+// EXIF IFDs left out of the info: 1 is the embedded thumbnail, 3 holds GPS
+// coordinates and 4 is interoperability data.
+var hiddenExifIFDs = []string{"exif-ifd1-", "exif-ifd3-", "exif-ifd4-"}
+
+// EXIF tags identifying the device or its owner, or holding binary data.
+var hiddenExifTags = map[string]bool{
+	"MakerNote":          true,
+	"UserComment":        true,
+	"BodySerialNumber":   true,
+	"CameraSerialNumber": true,
+	"LensSerialNumber":   true,
+	"ImageUniqueID":      true,
+	"CameraOwnerName":    true,
+}
+
+const maxExifValueLength = 256
+
+func NewImageInfo(imageBytes []byte, imageType vips.ImageType) (*ImageInfo, error) {
+	image, err := vips.LoadImageFromBuffer(imageBytes, vips.NewImportParams())
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	width, height := image.Width(), image.PageHeight()
+	orientedWidth, orientedHeight := width, height
+	if image.Orientation() > 4 {
+		orientedWidth, orientedHeight = height, width
+	}
+
+	pages := max(1, image.Pages())
+	frames := 1
+	if isAnimationSupported(imageType) {
+		frames = pages
+	}
+
+	colorSpace, exists := colorSpaceNames[image.Interpretation()]
+	if !exists {
+		colorSpace = "unknown"
+	}
+
+	return &ImageInfo{
+		Format:         ImageTypeName(imageType),
+		Mime:           MimeTypeFromImageType(imageType),
+		Width:          width,
+		Height:         height,
+		OrientedWidth:  orientedWidth,
+		OrientedHeight: orientedHeight,
+		Orientation:    image.Orientation(),
+		Pages:          pages,
+		Frames:         frames,
+		HasAlpha:       image.HasAlpha(),
+		ColorSpace:     colorSpace,
+		HasICCProfile:  image.HasICCProfile(),
+		Size:           len(imageBytes),
+		Exif:           sanitizeExif(image.GetExif()),
+	}, nil
+}
+
+// sanitizeExif turns libvips EXIF fields (e.g. "exif-ifd0-Make" with value
+// "Canon (Canon, ASCII, 6 components, 6 bytes)") into tag names and plain
+// values, leaving out location, serial numbers and binary data.
+func sanitizeExif(fields map[string]string) map[string]string {
+	exif := map[string]string{}
+
+	for field, value := range fields {
+		if !strings.HasPrefix(field, "exif-ifd") || hasAnyPrefix(field, hiddenExifIFDs) {
+			continue
+		}
+
+		tag := field[len("exif-ifd0-"):]
+		if hiddenExifTags[tag] {
+			continue
+		}
+
+		if i := strings.LastIndex(value, " ("); i >= 0 && strings.HasSuffix(value, ")") {
+			value = value[:i]
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" || len(value) > maxExifValueLength || strings.IndexFunc(value, isNotPrintable) >= 0 {
+			continue
+		}
+
+		exif[tag] = value
+	}
+
+	if len(exif) == 0 {
+		return nil
+	}
+
+	return exif
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNotPrintable(r rune) bool {
+	return !unicode.IsPrint(r)
+}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// ImageInfoHandler returns ImageInfo JSON for source images. It shares the
+// transform slots with ImageTransformHandler, as loading an image isn't free
+// either.
+type ImageInfoHandler struct {
+	transforms *ImageTransformHandler
+}
+
+func NewImageInfoHandler(transforms *ImageTransformHandler) *ImageInfoHandler {
+	return &ImageInfoHandler{transforms}
+}
+
+func (h *ImageInfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	imageSource := getImageSource(r.Context())
+
+	if !h.transforms.acquire(w, r) {
+		return
+	}
+	defer h.transforms.release()
+
+	downloadedFile, downloadedImageType, ok := h.transforms.downloadImage(w, imageSource)
+	if !ok {
+		return
+	}
+
+	info, err := NewImageInfo(downloadedFile.Buffer.Bytes(), downloadedImageType)
+	if err != nil {
+		slog.Error("Image info error", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", h.transforms.config.CacheControl)
+	writeJSONResponse(w, info)
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestSanitizeExif(t *testing.T) {
+	exif := sanitizeExif(map[string]string{
+		"exif-data":                  "",
+		"exif-ifd0-Make":             "Canon (Canon, ASCII, 6 components, 6 bytes)",
+		"exif-ifd2-FNumber":          "f/2.8 (28/10, Rational, 1 components, 8 bytes)",
+		"exif-ifd2-BodySerialNumber": "123456 (123456, ASCII, 7 components, 7 bytes)",
+		"exif-ifd3-GPSLatitude":      "52, 13, 30 (52/1 13/1 30/1, Rational, 3 components, 24 bytes)",
+		"exif-ifd1-Compression":      "JPEG compression (6, Short, 1 components, 2 bytes)",
+		"exif-ifd2-MakerNote":        "1234 bytes undefined data (..., Undefined, 1234 components, 1234 bytes)",
+		"exif-ifd0-ImageDescription": "line\x00break (..., ASCII, 11 components, 11 bytes)",
+		"exif-ifd0-DocumentName":     " (, ASCII, 1 components, 1 bytes)",
+	})
+
+	want := map[string]string{"Make": "Canon", "FNumber": "f/2.8"}
+	if len(exif) != len(want) {
+		t.Fatalf("exif = %#v, want %#v", exif, want)
+	}
+	for tag, value := range want {
+		if exif[tag] != value {
+			t.Fatalf("exif[%s] = %q, want %q", tag, exif[tag], value)
+		}
+	}
+
+	if got := sanitizeExif(map[string]string{"exif-ifd3-GPSLatitude": "52"}); got != nil {
+		t.Fatalf("sanitizeExif() = %#v, want nil", got)
+	}
+}
+
+func TestNewImageInfo(t *testing.T) {
+	info, err := NewImageInfo(makeOrientedJPEG(t, 40, 20, 6), vips.ImageTypeJPEG)
+	if err != nil {
+		t.Fatalf("NewImageInfo() error: %v", err)
+	}
+
+	if info.Format != "jpeg" || info.Mime != "image/jpeg" {
+		t.Fatalf("format = %s/%s", info.Format, info.Mime)
+	}
+	if info.Width != 40 || info.Height != 20 || info.OrientedWidth != 20 || info.OrientedHeight != 40 {
+		t.Fatalf("size = %dx%d, oriented %dx%d", info.Width, info.Height, info.OrientedWidth, info.OrientedHeight)
+	}
+	if info.Orientation != 6 || info.Pages != 1 || info.Frames != 1 || info.HasAlpha {
+		t.Fatalf("info = %+v", info)
+	}
+	if info.ColorSpace != "srgb" || info.Size == 0 {
+		t.Fatalf("colour space/size = %s/%d", info.ColorSpace, info.Size)
+	}
+
+	info, err = NewImageInfo(makeAnimatedGIF(t, 16, 8, 3), vips.ImageTypeGIF)
+	if err != nil {
+		t.Fatalf("NewImageInfo(gif) error: %v", err)
+	}
+	if info.Width != 16 || info.Height != 8 || info.Pages != 3 || info.Frames != 3 {
+		t.Fatalf("gif info = %+v", info)
+	}
+}
+
+func TestImageInfoHandler(t *testing.T) {
+	src := makePNG(t, 30, 10)
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImageInfoHandler(NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	}))
+
+	req := httptest.NewRequest("GET", "http://example.com/image/info/src/img", nil)
+	req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	var info ImageInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if info.Format != "png" || info.Width != 30 || info.Height != 10 || info.Size != len(src) {
+		t.Fatalf("info = %+v", info)
+	}
+}
@@ -0,0 +1,69 @@
+package internal
+
+import "encoding/base64"
+
+const (
+	// LQIPs fit within defaultLQIPSize x defaultLQIPSize px.
+	defaultLQIPSize      = 20
+	defaultLQIPQuality   = 40
+	defaultLQIPBlurSigma = 1.5
+)
+
+// Values of the output param.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// InlineImage is the JSON response for output=json.
+type InlineImage struct {
+	DataURI string `json:"dataUri"`
+	Mime    string `json:"mime"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Size    int    `json:"size"`
+}
+
+// applyLQIP turns the transform into a low-quality image placeholder: the
+// requested size (and pixel-based params) are scaled down to fit within
+// defaultLQIPSize, keeping the aspect ratio so that the placeholder matches the
+// full image, and the result is blurred and encoded at a low quality.
+func (o *ImageOptions) applyLQIP() {
+	if !o.LQIP {
+		return
+	}
+
+	if o.Width == 0 && o.Height == 0 {
+		o.Width, o.Height = defaultLQIPSize, defaultLQIPSize
+	} else if longest := max(o.Width, o.Height); longest > defaultLQIPSize {
+		o.scalePixelParams(float64(defaultLQIPSize) / float64(longest))
+	}
+
+	if o.RequestedQuality == "" {
+		o.Quality = defaultLQIPQuality
+	}
+
+	o.Animated = false
+
+	if !hasOperation(o, "blur") {
+		o.Operations = append(o.Operations, "blur")
+	}
+}
+
+func isValidOutput(value string) bool {
+	return value == outputText || value == outputJSON
+}
+
+func dataURI(processedImage *ProcessedImage) string {
+	return "data:" + processedImage.Mime + ";base64," + base64.StdEncoding.EncodeToString(processedImage.Bytes)
+}
+
+func newInlineImage(processedImage *ProcessedImage) *InlineImage {
+	return &InlineImage{
+		DataURI: dataURI(processedImage),
+		Mime:    processedImage.Mime,
+		Width:   processedImage.Width,
+		Height:  processedImage.Height,
+		Size:    processedImage.Size,
+	}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewImageOptionsFromRequestLQIP(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?lqip=true&op=fill&w=300&h=200&radius=30&dpr=2", nil))
+
+	if opts.Width != 20 || opts.Height != 13 || opts.Radius != 2 {
+		t.Fatalf("size = %dx%d, radius = %d, want 20x13 and 2", opts.Width, opts.Height, opts.Radius)
+	}
+	if len(opts.Operations) != 2 || opts.Operations[0] != "fill" || opts.Operations[1] != "blur" {
+		t.Fatalf("Operations = %#v", opts.Operations)
+	}
+	if opts.Quality != defaultLQIPQuality || opts.BlurSigma != defaultLQIPBlurSigma || opts.DPR != defaultDPR {
+		t.Fatalf("quality/blur/dpr = %d/%g/%g", opts.Quality, opts.BlurSigma, opts.DPR)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?lqip=true&q=60&blursigma=3&op=fit,blur", nil))
+	if opts.Width != defaultLQIPSize || opts.Height != defaultLQIPSize {
+		t.Fatalf("size = %dx%d, want %d", opts.Width, opts.Height, defaultLQIPSize)
+	}
+	if len(opts.Operations) != 2 || opts.Quality != 60 || opts.BlurSigma != 3 {
+		t.Fatalf("operations/quality/blur = %#v/%d/%g", opts.Operations, opts.Quality, opts.BlurSigma)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?w=300&output=HTML", nil))
+	if opts.LQIP || opts.Width != 300 || opts.Output != "" {
+		t.Fatalf("lqip/width/output = %v/%d/%q", opts.LQIP, opts.Width, opts.Output)
+	}
+}
+
+func TestImageTransformHandlerLQIPOutput(t *testing.T) {
+	src := makePNG(t, 200, 100)
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	})
+
+	request := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/image/transform/src/img?"+query, nil)
+		req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("lqip=true&format=jpeg&output=text")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("status = %d, content type = %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	uri := rr.Body.String()
+	encoded, found := strings.CutPrefix(uri, "data:image/jpeg;base64,")
+	if !found {
+		t.Fatalf("data URI = %q", uri)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(encoded); err != nil || len(decoded) == 0 {
+		t.Fatalf("data URI payload: %v", err)
+	}
+
+	rr = request("lqip=true&format=jpeg&output=json")
+	var inline InlineImage
+	if err := json.Unmarshal(rr.Body.Bytes(), &inline); err != nil {
+		t.Fatalf("unmarshal %q: %v", rr.Body.String(), err)
+	}
+	if inline.DataURI != uri || inline.Mime != "image/jpeg" || inline.Width != 20 || inline.Height != 10 {
+		t.Fatalf("inline image = %+v", inline)
+	}
+	if rr.Header().Get("ETag") == "" || rr.Header().Get("Cache-Control") != h.config.CacheControl {
+		t.Fatalf("LQIP responses should be cacheable: %v", rr.Header())
+	}
+}
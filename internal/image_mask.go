@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// RoundImage rounds the image corners by imageOptions.Radius pixels, or cuts
+// out a circle (centered on the focal point) when imageOptions.RadiusCircle is set.
+// The corners become transparent, so the output needs an alpha-capable format.
+func RoundImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Radius <= 0 && !imageOptions.RadiusCircle {
+		return fmt.Errorf("radius must be specified")
+	}
+
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	radius := imageOptions.Radius
+
+	if imageOptions.RadiusCircle {
+		side := min(image.Width(), image.Height())
+		left := cropOffset(image.Width(), side, imageOptions.FocalPointX)
+		top := cropOffset(image.Height(), side, imageOptions.FocalPointY)
+
+		if err := image.ExtractArea(left, top, side, side); err != nil {
+			return err
+		}
+
+		radius = side / 2
+	}
+
+	// The mask is 8-bit, so make sure the image is too.
+	if image.Bands() < 3 || image.BandFormat() != vips.BandFormatUchar {
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return err
+		}
+	}
+
+	mask, err := roundedRectMask(image.Width(), image.Height(), radius)
+	if err != nil {
+		return err
+	}
+	defer mask.Close()
+
+	if image.HasAlpha() {
+		// Combine with the existing transparency: alpha * mask / 255.
+		alpha, err := image.ExtractBandToImage(image.Bands()-1, 1)
+		if err != nil {
+			return err
+		}
+		defer alpha.Close()
+
+		if err := alpha.Multiply(mask); err != nil {
+			return err
+		}
+		if err := alpha.Linear1(1.0/255, 0); err != nil {
+			return err
+		}
+		if err := alpha.Cast(vips.BandFormatUchar); err != nil {
+			return err
+		}
+		if err := image.ExtractBand(0, image.Bands()-1); err != nil {
+			return err
+		}
+
+		mask = alpha
+	}
+
+	if err := image.BandJoin(mask); err != nil {
+		return err
+	}
+
+	imageOptions.KeepAlpha = true
+
+	return nil
+}
+
+// roundedRectMask returns a single-band, anti-aliased mask: 255 inside the
+// rounded rectangle, 0 outside. The shape is rendered by libvips from SVG.
+func roundedRectMask(width, height, radius int) (*vips.ImageRef, error) {
+	r := math.Min(float64(radius), math.Min(float64(width), float64(height))/2)
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+
+		`<rect width="%d" height="%d" rx="%g" ry="%g" fill="#fff"/></svg>`,
+		width, height, width, height, r, r)
+
+	mask, err := vips.LoadImageFromBuffer([]byte(svg), vips.NewImportParams())
+	if err != nil {
+		return nil, err
+	}
+
+	// The shape (and its anti-aliasing) is in the alpha band.
+	if err := mask.ExtractBand(mask.Bands()-1, 1); err != nil {
+		mask.Close()
+		return nil, err
+	}
+
+	return mask, nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestTransformImageRoundCorners(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"round"}, Radius: 10, Format: vips.ImageTypePNG, RequestedFormat: "png"}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 40 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 40x20", w, h)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 0); got.A != 0 {
+		t.Fatalf("corner alpha = %d, want 0", got.A)
+	}
+	if got := decodePixel(t, out.Bytes, 20, 10); got.A != 255 {
+		t.Fatalf("center alpha = %d, want 255", got.A)
+	}
+}
+
+func TestTransformImageRoundCircleCropsToSquare(t *testing.T) {
+	opts := &ImageOptions{
+		Operations:      []string{"round"},
+		RadiusCircle:    true,
+		Format:          vips.ImageTypePNG,
+		RequestedFormat: "png",
+		FocalPointX:     0.5,
+		FocalPointY:     0.5,
+	}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 20 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 20x20", w, h)
+	}
+	if got := decodePixel(t, out.Bytes, 2, 2); got.A != 0 {
+		t.Fatalf("outside of circle alpha = %d, want 0", got.A)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 10); got.A == 0 {
+		t.Fatalf("circle edge alpha = %d, want visible", got.A)
+	}
+}
+
+func TestTransformImageRoundSwitchesJPEGToPNG(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"round"}, Radius: 5, Format: vips.ImageTypeJPEG}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+	if out.Mime != "image/png" {
+		t.Fatalf("Mime = %q, want image/png", out.Mime)
+	}
+}
+
+func TestTransformImageRoundExplicitJPEGFlattens(t *testing.T) {
+	opts := &ImageOptions{
+		Operations:      []string{"round"},
+		Radius:          10,
+		Quality:         100,
+		Format:          vips.ImageTypeJPEG,
+		RequestedFormat: "jpeg",
+		Background:      vips.ColorRGBA{R: 255, G: 255, B: 255, A: 255},
+	}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+	if out.Mime != "image/jpeg" {
+		t.Fatalf("Mime = %q, want image/jpeg", out.Mime)
+	}
+
+	got := decodePixel(t, out.Bytes, 0, 0)
+	if got.R < 250 || got.G < 250 || got.B < 250 {
+		t.Fatalf("corner pixel = %v, want white background", got)
+	}
+}
+
+func TestTransformImageRoundRequiresRadius(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"round"}, Format: vips.ImageTypePNG}
+
+	if _, err := TransformImage(makePNG(t, 10, 10), opts); err == nil {
+		t.Fatalf("expected error when radius is missing")
+	}
+}
+
+func TestRoundedRectMask(t *testing.T) {
+	mask, err := roundedRectMask(40, 20, 100)
+	if err != nil {
+		t.Fatalf("roundedRectMask() error: %v", err)
+	}
+	defer mask.Close()
+
+	if mask.Width() != 40 || mask.Height() != 20 || mask.Bands() != 1 {
+		t.Fatalf("mask = %dx%d with %d bands, want 40x20 with 1 band", mask.Width(), mask.Height(), mask.Bands())
+	}
+
+	pixels, err := mask.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes(): %v", err)
+	}
+	// The radius is capped at half the height: the corners are outside, the
+	// middle row inside.
+	if pixels[0] != 0 || pixels[10*40+20] != 255 || pixels[10*40] == 0 {
+		t.Fatalf("corner/center/edge = %d/%d/%d", pixels[0], pixels[10*40+20], pixels[10*40])
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Values of the meta param.
+const (
+	metaNone      = "none"
+	metaCopyright = "copyright"
+	metaAll       = "all"
+)
+
+func isValidMeta(value string) bool {
+	return value == metaNone || value == metaCopyright || value == metaAll
+}
+
+// libvips metadata fields.
+const (
+	exifField = "exif-data"
+	iptcField = "iptc-data"
+	xmpField  = "xmp-data"
+)
+
+// EXIF tags kept with meta=copyright. libvips drops tags without a matching
+// image field from the EXIF block when saving.
+var copyrightExifFields = []string{
+	"exif-ifd0-Copyright",
+	"exif-ifd0-Artist",
+}
+
+// IPTC datasets (record 2) kept with meta=copyright.
+var copyrightIPTCDatasets = map[byte]bool{
+	80:  true, // By-line (creator)
+	85:  true, // By-line Title
+	110: true, // Credit
+	115: true, // Source
+	116: true, // Copyright Notice
+}
+
+var (
+	xmpElementPattern   = regexp.MustCompile(`(?s)<(dc:rights|dc:creator|xmpRights:\w+)[\s>].*?</(dc:rights|dc:creator|xmpRights:\w+)>`)
+	xmpAttributePattern = regexp.MustCompile(`\bxmpRights:(\w+)="([^"]*)"`)
+)
+
+// applyMetadataPolicy removes metadata according to imageOptions.Meta. With
+// meta=none (StripMetadata), the exporters strip the ICC profile as well,
+// unless it's kept with icc=keep.
+func applyMetadataPolicy(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	switch {
+	case imageOptions.Meta == metaCopyright:
+		return keepCopyrightMetadata(image)
+	case imageOptions.StripMetadata:
+		// Not every encoder supports stripping (e.g. HEIF), so remove the
+		// metadata here rather than relying on the export params.
+		return image.RemoveMetadata()
+	}
+
+	return nil
+}
+
+// keepCopyrightMetadata drops everything but creator and copyright fields, so
+// that e.g. GPS coordinates and camera serial numbers aren't published.
+func keepCopyrightMetadata(image *vips.ImageRef) error {
+	iptc := filterIPTC(image.GetBlob(iptcField))
+	xmp := filterXMP(image.GetBlob(xmpField))
+
+	keep := append([]string{exifField}, copyrightExifFields...)
+	if err := image.RemoveMetadata(keep...); err != nil {
+		return err
+	}
+
+	if len(iptc) > 0 {
+		image.SetBlob(iptcField, iptc)
+	}
+	if len(xmp) > 0 {
+		image.SetBlob(xmpField, xmp)
+	}
+
+	return nil
+}
+
+const photoshopHeader = "Photoshop 3.0\x00"
+
+// filterIPTC keeps copyrightIPTCDatasets from a Photoshop IRB block (as found
+// in JPEG APP13 segments), returning nil when none of them is present.
+func filterIPTC(data []byte) []byte {
+	iim := findIRBResource(bytes.TrimPrefix(data, []byte(photoshopHeader)), 0x0404)
+	if iim == nil {
+		return nil
+	}
+
+	var filtered bytes.Buffer
+	for len(iim) >= 5 && iim[0] == 0x1c {
+		record, dataset := iim[1], iim[2]
+		size := int(binary.BigEndian.Uint16(iim[3:5]))
+		if size&0x8000 != 0 || len(iim) < 5+size {
+			// Extended datasets are only used for huge values, which we don't need.
+			break
+		}
+
+		// Keep the character set (1:90), so that UTF-8 values stay readable.
+		if (record == 2 && copyrightIPTCDatasets[dataset]) || (record == 1 && dataset == 90) {
+			filtered.Write(iim[:5+size])
+		}
+
+		iim = iim[5+size:]
+	}
+
+	if !bytes.Contains(filtered.Bytes(), []byte{0x1c, 2}) {
+		return nil
+	}
+
+	var irb bytes.Buffer
+	irb.WriteString(photoshopHeader)
+	irb.WriteString("8BIM")
+	binary.Write(&irb, binary.BigEndian, uint16(0x0404))
+	irb.Write([]byte{0, 0}) // empty (padded) resource name
+	binary.Write(&irb, binary.BigEndian, uint32(filtered.Len()))
+	irb.Write(filtered.Bytes())
+	if filtered.Len()%2 != 0 {
+		irb.WriteByte(0)
+	}
+
+	return irb.Bytes()
+}
+
+// findIRBResource returns the data of the resource with the given id from a
+// list of Photoshop image resource blocks.
+func findIRBResource(data []byte, id uint16) []byte {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		resourceID := binary.BigEndian.Uint16(data[4:6])
+
+		// Pascal string name, padded to an even length.
+		nameSize := int(data[6]) + 1
+		nameSize += nameSize % 2
+
+		offset := 6 + nameSize
+		if len(data) < offset+4 {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if size < 0 || len(data) < offset+size {
+			return nil
+		}
+
+		if resourceID == id {
+			return data[offset : offset+size]
+		}
+
+		data = data[offset+size+size%2:]
+	}
+
+	return nil
+}
+
+// filterXMP builds a new XMP packet with the creator and rights properties
+// (dc:creator, dc:rights and xmpRights:*) of the original, returning nil when
+// none of them is present. Properties are matched by their conventional
+// prefixes.
+func filterXMP(data []byte) []byte {
+	var properties []string
+
+	for _, match := range xmpElementPattern.FindAllSubmatch(data, -1) {
+		if string(match[1]) == string(match[2]) {
+			properties = append(properties, string(match[0]))
+		}
+	}
+
+	for _, match := range xmpAttributePattern.FindAllSubmatch(data, -1) {
+		properties = append(properties, fmt.Sprintf("<xmpRights:%s>%s</xmpRights:%s>", match[1], match[2], match[1]))
+	}
+
+	if len(properties) == 0 {
+		return nil
+	}
+
+	return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` +
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/">` +
+		strings.Join(properties, "") +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`)
+}
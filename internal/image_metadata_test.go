@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/" xmpRights:Marked="True">` +
+	`<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>` +
+	`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">(c) Jane Doe</rdf:li></rdf:Alt></dc:rights>` +
+	`<exif:GPSLatitude>52,13.5N</exif:GPSLatitude>` +
+	`</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func makeIPTC(datasets map[[2]byte]string) []byte {
+	var iim bytes.Buffer
+	for key, value := range datasets {
+		iim.Write([]byte{0x1c, key[0], key[1]})
+		binary.Write(&iim, binary.BigEndian, uint16(len(value)))
+		iim.WriteString(value)
+	}
+
+	var irb bytes.Buffer
+	irb.WriteString(photoshopHeader)
+	irb.WriteString("8BIM")
+	binary.Write(&irb, binary.BigEndian, uint16(0x0404))
+	irb.Write([]byte{0, 0})
+	binary.Write(&irb, binary.BigEndian, uint32(iim.Len()))
+	irb.Write(iim.Bytes())
+
+	return irb.Bytes()
+}
+
+func TestFilterIPTC(t *testing.T) {
+	iptc := makeIPTC(map[[2]byte]string{
+		{2, 116}: "(c) Jane Doe",
+		{2, 80}:  "Jane Doe",
+		{2, 90}:  "Berlin",
+	})
+
+	filtered := filterIPTC(iptc)
+	if !bytes.Contains(filtered, []byte("(c) Jane Doe")) || !bytes.Contains(filtered, []byte("\x1c\x02\x50")) {
+		t.Fatalf("filtered IPTC should keep copyright and by-line: %q", filtered)
+	}
+	if bytes.Contains(filtered, []byte("Berlin")) {
+		t.Fatalf("filtered IPTC should drop the city: %q", filtered)
+	}
+
+	if got := filterIPTC(makeIPTC(map[[2]byte]string{{2, 90}: "Berlin"})); got != nil {
+		t.Fatalf("filterIPTC() = %q, want nil without copyright fields", got)
+	}
+}
+
+func TestFilterXMP(t *testing.T) {
+	filtered := string(filterXMP([]byte(testXMP)))
+
+	for _, want := range []string{"<dc:creator>", "(c) Jane Doe", "<xmpRights:Marked>True</xmpRights:Marked>"} {
+		if !bytes.Contains([]byte(filtered), []byte(want)) {
+			t.Fatalf("filtered XMP should contain %q: %s", want, filtered)
+		}
+	}
+	if bytes.Contains([]byte(filtered), []byte("GPS")) {
+		t.Fatalf("filtered XMP should drop GPS: %s", filtered)
+	}
+
+	if got := filterXMP([]byte(`<x:xmpmeta><exif:GPSLatitude>1</exif:GPSLatitude></x:xmpmeta>`)); got != nil {
+		t.Fatalf("filterXMP() = %q, want nil without rights fields", got)
+	}
+}
+
+func TestExportImageMetadataPolicy(t *testing.T) {
+	cases := []struct {
+		meta       string
+		wantXMP    bool
+		wantGPS    bool
+		wantRights bool
+	}{
+		{metaNone, false, false, false},
+		{metaCopyright, true, false, true},
+		{metaAll, true, true, true},
+	}
+
+	for _, tc := range cases {
+		image := loadTestImage(t, makePNG(t, 20, 20))
+		image.SetBlob(xmpField, []byte(testXMP))
+
+		opts := &ImageOptions{Format: vips.ImageTypeJPEG, Quality: 80, Meta: tc.meta, StripMetadata: tc.meta == metaNone}
+		out, err := ExportImage(image, opts)
+		if err != nil {
+			t.Fatalf("ExportImage(meta=%s) error: %v", tc.meta, err)
+		}
+
+		xmp := loadTestImage(t, out.Bytes).GetBlob(xmpField)
+		if got := len(xmp) > 0; got != tc.wantXMP {
+			t.Fatalf("meta=%s: has XMP = %v, want %v", tc.meta, got, tc.wantXMP)
+		}
+		if got := bytes.Contains(xmp, []byte("GPSLatitude")); got != tc.wantGPS {
+			t.Fatalf("meta=%s: has GPS = %v, want %v", tc.meta, got, tc.wantGPS)
+		}
+		if got := bytes.Contains(xmp, []byte("(c) Jane Doe")); got != tc.wantRights {
+			t.Fatalf("meta=%s: has rights = %v, want %v", tc.meta, got, tc.wantRights)
+		}
+	}
+}
+
+func TestExportHEIFStripsMetadata(t *testing.T) {
+	if !IsImageExportSupported(vips.ImageTypeHEIF) {
+		t.Skip("HEIF export not supported")
+	}
+
+	image := loadTestImage(t, makePNG(t, 20, 20))
+	image.SetBlob(xmpField, []byte(testXMP))
+
+	out, err := ExportImage(image, &ImageOptions{Format: vips.ImageTypeHEIF, Quality: 80, Meta: metaNone, StripMetadata: true})
+	if err != nil {
+		t.Fatalf("ExportImage() error: %v", err)
+	}
+
+	if xmp := loadTestImage(t, out.Bytes).GetBlob(xmpField); len(xmp) > 0 {
+		t.Fatalf("HEIF output should not contain XMP: %q", xmp)
+	}
+}
@@ -8,19 +8,52 @@ import (
 )
 
 type ProcessedImage struct {
-	Bytes []byte
-	Mime  string
-	Size  int
+	Bytes  []byte
+	Mime   string
+	Size   int
+	Width  int
+	Height int
+	// Quality the image was encoded with (may be lower than requested with maxbytes).
+	Quality int
 }
 
 var ImageOperationsMap = map[string]ImageOperation{
-	"fit":       FitImage,
-	"smartcrop": SmartCropImage,
-	"pixelate":  PixelateImage,
+	"fit":        FitImage,
+	"fill":       FillImage,
+	"cover":      FillImage,
+	"pad":        PadImage,
+	"contain":    PadImage,
+	"crop":       CropImage,
+	"rotate":     RotateImage,
+	"flip":       FlipImage,
+	"flop":       FlopImage,
+	"smartcrop":  SmartCropImage,
+	"pixelate":   PixelateImage,
+	"blur":       BlurImage,
+	"sharpen":    SharpenImage,
+	"trim":       TrimImage,
+	"grayscale":  GrayscaleImage,
+	"brightness": BrightnessImage,
+	"contrast":   ContrastImage,
+	"saturation": SaturationImage,
+	"gamma":      GammaImage,
+	"tint":       TintImage,
+	"duotone":    DuotoneImage,
+	"round":      RoundImage,
+	"watermark":  WatermarkImage,
 }
 
 type ImageOperation func(*vips.ImageRef, *ImageOptions) error
 
+func hasOperation(imageOptions *ImageOptions, name string) bool {
+	for _, operation := range imageOptions.Operations {
+		if operation == name {
+			return true
+		}
+	}
+	return false
+}
+
 func TransformImage(imageBytes []byte, imageOptions *ImageOptions) (*ProcessedImage, error) {
 	if len(imageOptions.Operations) == 0 {
 		return nil, fmt.Errorf("no operations specified")
@@ -28,7 +61,31 @@ func TransformImage(imageBytes []byte, imageOptions *ImageOptions) (*ProcessedIm
 
 	downloadedImageType := vips.DetermineImageType(imageBytes)
 	params := vips.NewImportParams()
-	params.Page.Set(importPageForImageType(imageOptions.Page, downloadedImageType))
+
+	frames := 1
+	page := importPageForImageType(imageOptions.Page, downloadedImageType)
+
+	if isAnimationSupported(downloadedImageType) {
+		if hasFrame(imageOptions) {
+			pages, err := imagePageCount(imageBytes)
+			if err != nil {
+				return nil, err
+			}
+			page = frameIndex(imageOptions, pages)
+		} else if imageOptions.Animated && canTransformAnimation(imageOptions) {
+			pages, err := imagePageCount(imageBytes)
+			if err != nil {
+				return nil, err
+			}
+			frames = animationFrameCount(pages, imageOptions.MaxFrames)
+		}
+	}
+
+	if frames > 1 {
+		params.NumPages.Set(frames)
+	} else {
+		params.Page.Set(page)
+	}
 
 	image, err := vips.LoadImageFromBuffer(imageBytes, params)
 	if err != nil {
@@ -36,6 +93,10 @@ func TransformImage(imageBytes []byte, imageOptions *ImageOptions) (*ProcessedIm
 	}
 	defer image.Close()
 
+	if err := applyColorProfile(image, imageOptions); err != nil {
+		return nil, err
+	}
+
 	for _, operation := range imageOptions.Operations {
 		operationFunc, exists := ImageOperationsMap[operation]
 
@@ -75,7 +136,7 @@ func FitImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
 	}
 
 	originalWidth := image.Width()
-	originalHeight := image.Height()
+	originalHeight := image.PageHeight()
 
 	if originalWidth == 0 || originalHeight == 0 {
 		return fmt.Errorf("invalid image size")
@@ -86,7 +147,7 @@ func FitImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
 	imageOptions.Width = finalWidth
 	imageOptions.Height = finalHeight
 
-	err := image.Thumbnail(imageOptions.Width, imageOptions.Height, vips.InterestingNone)
+	err := resizeImage(image, imageOptions.Width, imageOptions.Height, vips.SizeBoth)
 	if err != nil {
 		return err
 	}
@@ -113,6 +174,232 @@ func SmartCropImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
 	return nil
 }
 
+// FillImage resizes the image to cover the requested box and crops the overflow
+// around the focal point (either explicit or derived from gravity).
+func FillImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Width == 0 || imageOptions.Height == 0 {
+		return fmt.Errorf("width and height must be specified for fill")
+	}
+
+	// Focal points are relative to the image as displayed, so orientation
+	// has to be applied before we calculate the crop.
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	if image.Width() == 0 || image.PageHeight() == 0 {
+		return fmt.Errorf("invalid image size")
+	}
+
+	if imageOptions.Width > image.Width() || imageOptions.Height > image.PageHeight() {
+		scale := math.Min(float64(image.Width())/float64(imageOptions.Width), float64(image.PageHeight())/float64(imageOptions.Height))
+		imageOptions.Width = max(1, int(float64(imageOptions.Width)*scale))
+		imageOptions.Height = max(1, int(float64(imageOptions.Height)*scale))
+	}
+
+	scale := math.Max(float64(imageOptions.Width)/float64(image.Width()), float64(imageOptions.Height)/float64(image.PageHeight()))
+	coverWidth := max(imageOptions.Width, int(math.Round(float64(image.Width())*scale)))
+	coverHeight := max(imageOptions.Height, int(math.Round(float64(image.PageHeight())*scale)))
+
+	err := resizeImage(image, coverWidth, coverHeight, vips.SizeForce)
+	if err != nil {
+		return err
+	}
+
+	left := cropOffset(image.Width(), imageOptions.Width, imageOptions.FocalPointX)
+	top := cropOffset(image.PageHeight(), imageOptions.Height, imageOptions.FocalPointY)
+
+	return image.ExtractArea(left, top, imageOptions.Width, imageOptions.Height)
+}
+
+// PadImage fits the image within the requested box and extends the canvas to
+// exactly that size, filling the rest with imageOptions.Background. The image
+// is positioned according to the focal point (or gravity).
+func PadImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Width == 0 || imageOptions.Height == 0 {
+		return fmt.Errorf("width and height must be specified for pad")
+	}
+
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	width, height := imageOptions.Width, imageOptions.Height
+
+	if err := FitImage(image, imageOptions); err != nil {
+		return err
+	}
+
+	// FitImage stores the fitted size, but the output is the padded box.
+	imageOptions.Width, imageOptions.Height = width, height
+
+	if err := ensureColorImage(image); err != nil {
+		return err
+	}
+
+	background := imageOptions.Background
+	if background.A < 255 {
+		if err := image.AddAlpha(); err != nil {
+			return err
+		}
+	}
+
+	left := int(math.Round(imageOptions.FocalPointX * float64(width-image.Width())))
+	top := int(math.Round(imageOptions.FocalPointY * float64(height-image.PageHeight())))
+
+	return image.EmbedBackgroundRGBA(left, top, width, height, &background)
+}
+
+// CropImage extracts the rectangle given in imageOptions.Crop. Coordinates refer
+// to the image as displayed, i.e. after applying EXIF orientation.
+func CropImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Crop.Width <= 0 || imageOptions.Crop.Height <= 0 {
+		return fmt.Errorf("crop rectangle must be specified")
+	}
+
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	left, top, width, height := cropRectToPixels(imageOptions.Crop, image.Width(), image.PageHeight())
+
+	if width <= 0 || height <= 0 || left+width > image.Width() || top+height > image.PageHeight() {
+		return fmt.Errorf("crop rectangle %d,%d,%dx%d is outside of image bounds %dx%d", left, top, width, height, image.Width(), image.PageHeight())
+	}
+
+	return image.ExtractArea(left, top, width, height)
+}
+
+func cropRectToPixels(rect CropRect, imageWidth, imageHeight int) (int, int, int, int) {
+	if !rect.Relative {
+		return int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height)
+	}
+
+	// Round the edges rather than the size, so that adjacent relative
+	// rectangles (e.g. 0,0,0.5,1 and 0.5,0,0.5,1) never overflow the image.
+	left := int(math.Round(rect.X * float64(imageWidth)))
+	top := int(math.Round(rect.Y * float64(imageHeight)))
+	right := int(math.Round((rect.X + rect.Width) * float64(imageWidth)))
+	bottom := int(math.Round((rect.Y + rect.Height) * float64(imageHeight)))
+
+	return left, top, right - left, bottom - top
+}
+
+// RotateImage rotates the image clockwise by imageOptions.Angle degrees. Right
+// angles are lossless; other angles expand the canvas and fill the corners with
+// imageOptions.Background.
+func RotateImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	angle := math.Mod(imageOptions.Angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	switch angle {
+	case 0:
+		return nil
+	case 90:
+		return image.Rotate(vips.Angle90)
+	case 180:
+		return image.Rotate(vips.Angle180)
+	case 270:
+		return image.Rotate(vips.Angle270)
+	}
+
+	if imageOptions.Background.A < 255 && !image.HasAlpha() {
+		if err := image.AddAlpha(); err != nil {
+			return err
+		}
+	}
+
+	return image.Similarity(1.0, angle, &imageOptions.Background, 0, 0, 0, 0)
+}
+
+// FlipImage mirrors the image vertically (upside down).
+func FlipImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	return image.Flip(vips.DirectionVertical)
+}
+
+// FlopImage mirrors the image horizontally (left to right).
+func FlopImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	return image.Flip(vips.DirectionHorizontal)
+}
+
+// TrimImage removes borders of near-uniform colour, using the top-left pixel as
+// the border colour. Pixels differing from it by less than imageOptions.TrimThreshold
+// are treated as border.
+func TrimImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if err := ensureColorImage(image); err != nil {
+		return err
+	}
+
+	point, err := image.GetPoint(0, 0)
+	if err != nil {
+		return err
+	}
+
+	// GetPoint returns values in the image's own range, FindTrim expects 0-255.
+	scale := 1.0
+	if image.BandFormat() == vips.BandFormatUshort {
+		scale = 257
+	}
+
+	background := &vips.Color{
+		R: uint8(point[0] / scale),
+		G: uint8(point[1] / scale),
+		B: uint8(point[2] / scale),
+	}
+
+	left, top, width, height, err := image.FindTrim(imageOptions.TrimThreshold, background)
+	if err != nil {
+		return err
+	}
+
+	// Nothing but border (a blank image): keep it as is.
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+
+	if left == 0 && top == 0 && width == image.Width() && height == image.Height() {
+		return nil
+	}
+
+	return image.ExtractArea(left, top, width, height)
+}
+
+// ensureColorImage converts greyscale images to sRGB, so that operations can
+// use RGB(A) colours regardless of the source.
+func ensureColorImage(image *vips.ImageRef) error {
+	if image.Bands() >= 3 {
+		return nil
+	}
+
+	return image.ToColorSpace(vips.InterpretationSRGB)
+}
+
+// applyOrientation bakes the EXIF orientation into the pixels (when auto-rotation
+// is enabled), so that operations working with the displayed geometry see the
+// same image the user does. It resets the orientation tag, which means the
+// orientation handling in fitSizeToLimits and ExportImage becomes a no-op.
+func applyOrientation(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if !imageOptions.AutoRotate {
+		return nil
+	}
+
+	return image.AutoRotate()
+}
+
 func PixelateImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
 	if imageOptions.PixelateFactor == 0 {
 		return fmt.Errorf("pixelate factor must be specified (non-zero)")
@@ -125,6 +412,26 @@ func PixelateImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
 	return nil
 }
 
+func BlurImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.BlurSigma <= 0 {
+		return fmt.Errorf("blur sigma must be specified (greater than zero)")
+	}
+
+	return image.GaussianBlur(math.Min(imageOptions.BlurSigma, maxBlurSigma))
+}
+
+func SharpenImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.SharpenSigma <= 0 {
+		return fmt.Errorf("sharpen sigma must be specified (greater than zero)")
+	}
+
+	sigma := math.Min(imageOptions.SharpenSigma, maxSharpenSigma)
+	flat := clampFloat(imageOptions.SharpenFlat, 0, maxSharpenFlat)
+	jagged := clampFloat(imageOptions.SharpenJagged, 0, maxSharpenJagged)
+
+	return image.Sharpen(sigma, flat, jagged)
+}
+
 func fitSizeToLimits(image *vips.ImageRef, imageOptions *ImageOptions) (int, int) {
 	var originalWidth, originalHeight, fitWidth, fitHeight int
 
@@ -140,11 +447,11 @@ func fitSizeToLimits(image *vips.ImageRef, imageOptions *ImageOptions) (int, int
 	// 8: CW 90
 	if !imageOptions.AutoRotate || image.Orientation() <= 4 {
 		originalWidth = image.Width()
-		originalHeight = image.Height()
+		originalHeight = image.PageHeight()
 		fitWidth = imageOptions.Width
 		fitHeight = imageOptions.Height
 	} else {
-		originalWidth = image.Height()
+		originalWidth = image.PageHeight()
 		originalHeight = image.Width()
 		fitWidth = imageOptions.Height
 		fitHeight = imageOptions.Width
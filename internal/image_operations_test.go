@@ -10,6 +10,7 @@ import (
 	"github.com/davidbyttow/govips/v2/vips"
 )
 
+// makePNG returns an opaque PNG filled with (200, 100, 50).
 func makePNG(t *testing.T, width, height int) []byte {
 	t.Helper()
 
@@ -114,3 +115,603 @@ func TestImportPageForImageType(t *testing.T) {
 		t.Fatalf("GIF page should not be remapped, got %d", got)
 	}
 }
+
+func makeSplitPNG(t *testing.T, width, height int, left, right color.RGBA) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode(): %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func decodePixel(t *testing.T, data []byte, x, y int) color.RGBA {
+	t.Helper()
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.Decode(): %v", err)
+	}
+
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+func TestTransformImageFillProducesExactSize(t *testing.T) {
+	src := makePNG(t, 40, 20)
+	opts := &ImageOptions{
+		Operations:  []string{"fill"},
+		Width:       10,
+		Height:      10,
+		Quality:     80,
+		Format:      vips.ImageTypePNG,
+		AutoRotate:  true,
+		FocalPointX: 0.5,
+		FocalPointY: 0.5,
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 10 || h != 10 {
+		t.Fatalf("result size = %dx%d, want 10x10", w, h)
+	}
+}
+
+func TestTransformImageFillHonorsFocalPoint(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src := makeSplitPNG(t, 40, 20, red, blue)
+
+	cases := []struct {
+		name   string
+		focalX float64
+		want   color.RGBA
+	}{
+		{"west", 0, red},
+		{"east", 1, blue},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &ImageOptions{
+				Operations:  []string{"fill"},
+				Width:       10,
+				Height:      10,
+				Format:      vips.ImageTypePNG,
+				FocalPointX: tc.focalX,
+				FocalPointY: 0.5,
+			}
+
+			out, err := TransformImage(src, opts)
+			if err != nil {
+				t.Fatalf("TransformImage() error: %v", err)
+			}
+
+			if got := decodePixel(t, out.Bytes, 5, 5); got != tc.want {
+				t.Fatalf("center pixel = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransformImageFillRequiresBothDimensions(t *testing.T) {
+	src := makePNG(t, 40, 20)
+	opts := &ImageOptions{Operations: []string{"fill"}, Width: 10, Format: vips.ImageTypePNG}
+
+	if _, err := TransformImage(src, opts); err == nil {
+		t.Fatalf("expected error when height is missing")
+	}
+}
+
+func TestTransformImageCropThenFit(t *testing.T) {
+	src := makePNG(t, 40, 20)
+	opts := &ImageOptions{
+		Operations: []string{"crop", "fit"},
+		Width:      5,
+		Format:     vips.ImageTypePNG,
+		AutoRotate: true,
+		Crop:       CropRect{X: 10, Y: 0, Width: 20, Height: 20},
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 5 || h != 5 {
+		t.Fatalf("result size = %dx%d, want 5x5", w, h)
+	}
+}
+
+func TestTransformImageCropRelative(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src := makeSplitPNG(t, 40, 20, red, blue)
+	opts := &ImageOptions{
+		Operations: []string{"crop"},
+		Format:     vips.ImageTypePNG,
+		Crop:       CropRect{X: 0.5, Y: 0, Width: 0.5, Height: 1, Relative: true},
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 20 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 20x20", w, h)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 0); got != blue {
+		t.Fatalf("pixel = %v, want %v", got, blue)
+	}
+}
+
+func TestTransformImageCropOutOfBounds(t *testing.T) {
+	src := makePNG(t, 40, 20)
+	opts := &ImageOptions{
+		Operations: []string{"crop"},
+		Format:     vips.ImageTypePNG,
+		Crop:       CropRect{X: 30, Y: 0, Width: 20, Height: 20},
+	}
+
+	if _, err := TransformImage(src, opts); err == nil {
+		t.Fatalf("expected out of bounds error")
+	}
+}
+
+func TestCropRectToPixels(t *testing.T) {
+	left, top, width, height := cropRectToPixels(CropRect{X: 0.5, Y: 0.25, Width: 0.5, Height: 0.5, Relative: true}, 41, 20)
+	if left+width != 41 || top != 5 || height != 10 {
+		t.Fatalf("cropRectToPixels() = %d,%d,%d,%d", left, top, width, height)
+	}
+}
+
+func makeOrientedJPEG(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+
+	img, err := vips.LoadImageFromBuffer(makePNG(t, width, height), nil)
+	if err != nil {
+		t.Fatalf("LoadImageFromBuffer(): %v", err)
+	}
+	defer img.Close()
+
+	if err := img.SetOrientation(orientation); err != nil {
+		t.Fatalf("SetOrientation(): %v", err)
+	}
+
+	params := vips.NewJpegExportParams()
+	params.StripMetadata = false
+	out, _, err := img.ExportJpeg(params)
+	if err != nil {
+		t.Fatalf("ExportJpeg(): %v", err)
+	}
+
+	return out
+}
+
+func TestTransformImageRotateRightAngles(t *testing.T) {
+	cases := []struct {
+		angle         float64
+		width, height int
+	}{
+		{90, 20, 40},
+		{-90, 20, 40},
+		{180, 40, 20},
+		{360, 40, 20},
+	}
+
+	for _, tc := range cases {
+		opts := &ImageOptions{Operations: []string{"rotate"}, Angle: tc.angle, Format: vips.ImageTypePNG, AutoRotate: true}
+
+		out, err := TransformImage(makePNG(t, 40, 20), opts)
+		if err != nil {
+			t.Fatalf("TransformImage(angle=%v) error: %v", tc.angle, err)
+		}
+
+		w, h := decodeImageSize(t, out.Bytes)
+		if w != tc.width || h != tc.height {
+			t.Fatalf("angle=%v: result size = %dx%d, want %dx%d", tc.angle, w, h, tc.width, tc.height)
+		}
+	}
+}
+
+func TestTransformImageRotateArbitraryAngleExpandsCanvas(t *testing.T) {
+	opts := &ImageOptions{
+		Operations: []string{"rotate"},
+		Angle:      45,
+		Background: vips.ColorRGBA{R: 255, G: 255, B: 255, A: 255},
+		Format:     vips.ImageTypePNG,
+	}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w <= 40 || h <= 20 {
+		t.Fatalf("result size = %dx%d, want canvas larger than 40x20", w, h)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 0); got != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("corner pixel = %v, want background", got)
+	}
+}
+
+func TestTransformImageRotateThenFitHonorsEXIFOrientation(t *testing.T) {
+	// Stored as 40x20, displayed as 20x40 (orientation 6: rotate 90 CW).
+	src := makeOrientedJPEG(t, 40, 20, 6)
+	opts := &ImageOptions{
+		Operations:    []string{"rotate", "fit"},
+		Angle:         90,
+		Width:         10,
+		StripMetadata: true,
+		AutoRotate:    true,
+		Format:        vips.ImageTypePNG,
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 10 || h != 5 {
+		t.Fatalf("result size = %dx%d, want 10x5", w, h)
+	}
+}
+
+func TestTransformImageFlipAndFlop(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src := makeSplitPNG(t, 40, 20, red, blue)
+
+	out, err := TransformImage(src, &ImageOptions{Operations: []string{"flop"}, Format: vips.ImageTypePNG})
+	if err != nil {
+		t.Fatalf("TransformImage(flop) error: %v", err)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 0); got != blue {
+		t.Fatalf("flop: left pixel = %v, want %v", got, blue)
+	}
+
+	out, err = TransformImage(src, &ImageOptions{Operations: []string{"flip"}, Format: vips.ImageTypePNG})
+	if err != nil {
+		t.Fatalf("TransformImage(flip) error: %v", err)
+	}
+	if got := decodePixel(t, out.Bytes, 0, 0); got != red {
+		t.Fatalf("flip: left pixel = %v, want %v", got, red)
+	}
+}
+
+func TestTransformImageBlurAndSharpen(t *testing.T) {
+	src := makeSplitPNG(t, 40, 20, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255})
+	opts := &ImageOptions{
+		Operations:    []string{"blur", "sharpen"},
+		BlurSigma:     2,
+		SharpenSigma:  0.5,
+		SharpenFlat:   2,
+		SharpenJagged: 3,
+		Format:        vips.ImageTypePNG,
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 40 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 40x20", w, h)
+	}
+}
+
+func TestTransformImageBlurRequiresSigma(t *testing.T) {
+	src := makePNG(t, 10, 10)
+	opts := &ImageOptions{Operations: []string{"blur"}, Format: vips.ImageTypePNG}
+
+	if _, err := TransformImage(src, opts); err == nil {
+		t.Fatalf("expected error for zero sigma")
+	}
+}
+
+func TestTransformImagePadLetterboxes(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	src := makeSplitPNG(t, 40, 20, red, red)
+	opts := &ImageOptions{
+		Operations:  []string{"pad"},
+		Width:       20,
+		Height:      20,
+		Format:      vips.ImageTypePNG,
+		AutoRotate:  true,
+		Background:  vips.ColorRGBA{G: 255, A: 255},
+		FocalPointX: 0.5,
+		FocalPointY: 0.5,
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 20 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 20x20", w, h)
+	}
+	if got := decodePixel(t, out.Bytes, 10, 0); got != green {
+		t.Fatalf("top pixel = %v, want background %v", got, green)
+	}
+	if got := decodePixel(t, out.Bytes, 10, 10); got != red {
+		t.Fatalf("center pixel = %v, want image %v", got, red)
+	}
+}
+
+func TestTransformImagePadTransparentBackground(t *testing.T) {
+	opts := &ImageOptions{
+		Operations:  []string{"pad"},
+		Width:       20,
+		Height:      20,
+		Format:      vips.ImageTypePNG,
+		Background:  vips.ColorRGBA{},
+		FocalPointX: 0.5,
+		FocalPointY: 1,
+	}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if got := decodePixel(t, out.Bytes, 0, 0); got.A != 0 {
+		t.Fatalf("top-left pixel alpha = %d, want 0", got.A)
+	}
+	if got := decodePixel(t, out.Bytes, 10, 19); got.A != 255 {
+		t.Fatalf("bottom pixel alpha = %d, want 255 (image aligned to the bottom)", got.A)
+	}
+}
+
+func TestExportJPEGFlattensToBackground(t *testing.T) {
+	opts := &ImageOptions{
+		Operations:  []string{"pad"},
+		Width:       20,
+		Height:      20,
+		Quality:     100,
+		Format:      vips.ImageTypeJPEG,
+		Background:  vips.ColorRGBA{R: 255, G: 255, B: 255},
+		FocalPointX: 0.5,
+		FocalPointY: 1,
+	}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	got := decodePixel(t, out.Bytes, 0, 0)
+	if got.R < 250 || got.G < 250 || got.B < 250 {
+		t.Fatalf("flattened pixel = %v, want white", got)
+	}
+}
+
+func makeBorderedPNG(t *testing.T, width, height, border int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < border || y < border || x >= width-border || y >= height-border {
+				img.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode(): %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTransformImageTrimRemovesBorders(t *testing.T) {
+	src := makeBorderedPNG(t, 60, 40, 10)
+	opts := &ImageOptions{Operations: []string{"trim"}, TrimThreshold: 10, Format: vips.ImageTypePNG}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 40 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 40x20", w, h)
+	}
+}
+
+func TestTransformImageTrimThenFit(t *testing.T) {
+	src := makeBorderedPNG(t, 60, 40, 10)
+	opts := &ImageOptions{Operations: []string{"trim", "fit"}, Width: 20, TrimThreshold: 10, Format: vips.ImageTypePNG}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 20 || h != 10 {
+		t.Fatalf("result size = %dx%d, want 20x10", w, h)
+	}
+}
+
+func TestTransformImageTrimKeepsUniformImage(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"trim"}, TrimThreshold: 10, Format: vips.ImageTypePNG}
+
+	out, err := TransformImage(makePNG(t, 40, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 40 || h != 20 {
+		t.Fatalf("result size = %dx%d, want 40x20", w, h)
+	}
+}
+
+func TestExportJXL(t *testing.T) {
+	if !vips.IsTypeSupported(vips.ImageTypeJXL) {
+		t.Skip("libvips built without JPEG XL support")
+	}
+
+	opts := &ImageOptions{
+		Operations: []string{"fit"},
+		Width:      10,
+		Format:     vips.ImageTypeJXL,
+		Quality:    80,
+		Lossless:   true,
+		Effort:     20,
+	}
+
+	out, err := TransformImage(makePNG(t, 20, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if out.Mime != "image/jxl" {
+		t.Fatalf("mime = %q, want image/jxl", out.Mime)
+	}
+}
+
+func TestExportImageAutoFormatKeepsSourceAlpha(t *testing.T) {
+	src := makeSplitPNG(t, 20, 20, color.RGBA{}, color.RGBA{R: 255, A: 255})
+
+	opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, RequestedFormat: formatAuto}
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+	if out.Mime != "image/png" {
+		t.Fatalf("mime = %q, want image/png for a transparent source", out.Mime)
+	}
+
+	opts = &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, RequestedFormat: formatAuto}
+	out, err = TransformImage(makePNG(t, 20, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+	if out.Mime != "image/jpeg" {
+		t.Fatalf("mime = %q, want image/jpeg for an opaque source", out.Mime)
+	}
+}
+
+func TestExportPNGPalette(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, Quality: 80, Palette: true, PaletteDepth: 4}
+
+	out, err := TransformImage(makePNG(t, 20, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(out.Bytes))
+	if err != nil {
+		t.Fatalf("png.Decode(): %v", err)
+	}
+	if _, ok := img.(*image.Paletted); !ok {
+		t.Fatalf("image type = %T, want a palette image", img)
+	}
+}
+
+func TestExportPNGPaletteDepthWithoutPalette(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypePNG, Quality: 80, Palette: false, PaletteDepth: 4}
+
+	out, err := TransformImage(makePNG(t, 20, 20), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if got := decodePixel(t, out.Bytes, 10, 10); got.R != 200 || got.G != 100 || got.B != 50 {
+		t.Fatalf("pixel = %+v, colours should be kept without a palette", got)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	a := make([]byte, 16*16)
+	b := make([]byte, 16*16)
+	for i := range a {
+		a[i] = byte(i)
+		b[i] = byte(i)
+	}
+
+	if got := ssim(a, b, 16, 16); got < 0.9999 {
+		t.Fatalf("ssim(identical) = %v, want 1", got)
+	}
+
+	for i := range b {
+		b[i] = 255 - a[i]
+	}
+	if got := ssim(a, b, 16, 16); got > 0.5 {
+		t.Fatalf("ssim(inverted) = %v, want a low score", got)
+	}
+}
+
+func TestTransformImageAutoQuality(t *testing.T) {
+	src := makeNoisePNG(t, 64, 64)
+
+	strict, err := TransformImage(src, &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, AutoQuality: true, AutoQualityTarget: 0.99})
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	loose, err := TransformImage(src, &ImageOptions{Operations: []string{"fit"}, Format: vips.ImageTypeJPEG, AutoQuality: true, AutoQualityTarget: 0.5})
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	if loose.Quality > strict.Quality {
+		t.Fatalf("quality for a lower target = %d, want at most %d", loose.Quality, strict.Quality)
+	}
+	if strict.Quality < minAutoQuality || strict.Quality > maxAutoQuality {
+		t.Fatalf("quality = %d, want between %d and %d", strict.Quality, minAutoQuality, maxAutoQuality)
+	}
+}
+
+func TestLuminanceFlattensAgainstBackground(t *testing.T) {
+	image := loadTestImage(t, makePNG(t, 4, 4))
+
+	if err := image.AddAlpha(); err != nil {
+		t.Fatalf("AddAlpha(): %v", err)
+	}
+	// Make the image fully transparent.
+	if err := image.Linear([]float64{1, 1, 1, 0}, []float64{0, 0, 0, 0}); err != nil {
+		t.Fatalf("Linear(): %v", err)
+	}
+
+	for _, background := range []vips.ColorRGBA{{R: 0, G: 0, B: 0, A: 255}, {R: 255, G: 255, B: 255, A: 255}} {
+		pixels, err := luminance(image, background)
+		if err != nil {
+			t.Fatalf("luminance() error: %v", err)
+		}
+		if got := pixels[0]; absDiff(got, background.R) > 1 {
+			t.Fatalf("luminance over %+v = %d, want %d", background, got, background.R)
+		}
+	}
+}
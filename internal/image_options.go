@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/davidbyttow/govips/v2/vips"
@@ -9,36 +12,224 @@ import (
 
 // Allowed URL parameters
 const (
-	ParamOperations     = "op"
-	ParamWidth          = "w"
-	ParamHeight         = "h"
-	ParamQuality        = "q"
-	ParamStripMetadata  = "strip"
-	ParamFormat         = "format"
-	ParamPixelateFactor = "pixelatefactor"
+	ParamOperations       = "op"
+	ParamWidth            = "w"
+	ParamHeight           = "h"
+	ParamQuality          = "q"
+	ParamStripMetadata    = "strip"
+	ParamMeta             = "meta"
+	ParamAutoRotate       = "autorotate"
+	ParamFormat           = "format"
+	ParamPixelateFactor   = "pixelatefactor"
+	ParamGravity          = "gravity"
+	ParamFocalPoint       = "fp"
+	ParamCrop             = "crop"
+	ParamAngle            = "angle"
+	ParamBackground       = "bg"
+	ParamBlurSigma        = "blursigma"
+	ParamSharpenSigma     = "sharpensigma"
+	ParamSharpenFlat      = "sharpenflat"
+	ParamSharpenJagged    = "sharpenjagged"
+	ParamTrimThreshold    = "trimthreshold"
+	ParamBrightness       = "brightness"
+	ParamContrast         = "contrast"
+	ParamSaturation       = "saturation"
+	ParamGamma            = "gamma"
+	ParamTint             = "tint"
+	ParamDuotone          = "duotone"
+	ParamRadius           = "radius"
+	ParamDPR              = "dpr"
+	ParamAnimated         = "animated"
+	ParamFrame            = "frame"
+	ParamLossless         = "lossless"
+	ParamNearLossless     = "nearlossless"
+	ParamEffort           = "effort"
+	ParamProgressive      = "progressive"
+	ParamChroma           = "chroma"
+	ParamBitDepth         = "bitdepth"
+	ParamPalette          = "palette"
+	ParamPaletteDepth     = "palettedepth"
+	ParamColors           = "colors"
+	ParamCompression      = "compression"
+	ParamMaxBytes         = "maxbytes"
+	ParamICC              = "icc"
+	ParamLQIP             = "lqip"
+	ParamHash             = "hash"
+	ParamComponents       = "components"
+	ParamOutput           = "output"
+	ParamWatermark        = "wm"
+	ParamWatermarkGravity = "wmgravity"
+	ParamWatermarkMargin  = "wmmargin"
+	ParamWatermarkOpacity = "wmopacity"
+	ParamWatermarkScale   = "wmscale"
 )
 
 type ImageOptions struct {
-	Operations      []string
-	Width           int
-	Height          int
-	Quality         int
+	Operations []string
+	Width      int
+	Height     int
+	Quality    int
+	// RequestedQuality is the raw q param, empty when the default applies.
+	RequestedQuality string
+	// FormatQualities are the configured default qualities per output format
+	// (set from the config by the handler), see applyFormatQuality.
+	FormatQualities map[vips.ImageType]int
+	// AutoQuality (q=auto) picks the lowest quality that keeps the output
+	// similar to the source, with AutoQualityTarget as the minimum SSIM (set
+	// from the config by the handler).
+	AutoQuality       bool
+	AutoQualityTarget float64
+	// MaxBytes is the output size budget: quality (and if needed, size) is
+	// lowered until the image fits. 0 disables the budget.
+	MaxBytes        int
 	StripMetadata   bool
 	Format          vips.ImageType
 	RequestedFormat string
+	ICO             bool // favicon container; Format is PNG, the encoding of its icons
 	AutoRotate      bool
 	PixelateFactor  int
 	Page            int
+	Gravity         string
+	FocalPointX     float64
+	FocalPointY     float64
+	Crop            CropRect
+	Angle           float64
+	Background      vips.ColorRGBA
+	BlurSigma       float64
+	SharpenSigma    float64
+	SharpenFlat     float64
+	SharpenJagged   float64
+	TrimThreshold   float64
+	Brightness      float64
+	Contrast        float64
+	Saturation      float64
+	Gamma           float64
+	Tint            *vips.ColorRGBA
+	Duotone         *[2]vips.ColorRGBA
+	Radius          int
+	RadiusCircle    bool
+	// KeepAlpha is set by operations producing meaningful transparency, so that
+	// ExportImage doesn't pick a format without an alpha channel.
+	KeepAlpha bool
+	// DPR is the device pixel ratio all pixel-based params have been scaled by.
+	DPR          float64
+	RequestedDPR string
+
+	// Meta is the metadata policy: none, copyright (creator and rights
+	// fields only) or all. StripMetadata is set for meta=none.
+	Meta string
+
+	// ICC controls the embedded colour profile: keep, srgb (convert and drop
+	// the profile when stripping metadata) or strip.
+	ICC string
+
+	// Encoder params, passed to the encoders that support them. Zero values
+	// (and nil pointers) leave the encoder defaults in place.
+	Lossless     bool
+	NearLossless bool
+	Effort       int
+	Progressive  *bool
+	Chroma       string
+	BitDepth     int
+	Palette      bool
+	PaletteDepth int
+	Compression  *int
+
+	Animated bool
+	// Frame is the zero-based animation frame to use as a still image.
+	// FrameMiddle picks the middle frame instead.
+	Frame       *int
+	FrameMiddle bool
+	// MaxFrames limits the number of animation frames (set from the config by the handler).
+	MaxFrames int
+
+	Watermark        string
+	WatermarkGravity string
+	WatermarkMargin  int
+	WatermarkOpacity float64
+	WatermarkScale   float64
+	// WatermarkImage is the decoded overlay, resolved from Watermark by the handler.
+	WatermarkImage *vips.ImageRef
+	// WatermarkVersion identifies the overlay content (see WatermarkCache.Get).
+	WatermarkVersion string
+
+	// LQIP turns the output into a tiny, blurred placeholder (see applyLQIP).
+	LQIP bool
+	// Output is how the image is returned: as is (empty), or as a data URI in
+	// plain text or JSON.
+	Output string
+}
+
+// CropRect is a rectangle to extract from the source image. When Relative is
+// set, all values are fractions (0-1) of the source dimensions.
+type CropRect struct {
+	X        float64
+	Y        float64
+	Width    float64
+	Height   float64
+	Relative bool
 }
 
 const (
 	defaultOperation      = "fit"
 	defaultQuality        = 80
 	defaultStripMetadata  = true
+	defaultAutoRotate     = true
 	defaultPixelateFactor = 20
 	defaultPage           = 1
+	defaultBlurSigma      = 5
+	defaultSharpenSigma   = 0.5
+	defaultSharpenFlat    = 2
+	defaultSharpenJagged  = 3
+	defaultTrimThreshold  = 10
+	defaultBrightness     = 1.0
+	defaultContrast       = 1.0
+	defaultSaturation     = 1.0
+	defaultGamma          = 1.0
+
+	defaultWatermarkGravity = "south-east"
+	defaultWatermarkMargin  = 10
+	defaultWatermarkOpacity = 1.0
+	defaultWatermarkScale   = 0.25
+
+	// Upper bounds for convolution params: the cost of blur/sharpen grows with
+	// sigma, so a hostile (but signed) URL could otherwise tie up a transform slot.
+	maxBlurSigma     = 50
+	maxSharpenSigma  = 10
+	maxSharpenFlat   = 100
+	maxSharpenJagged = 100
+
+	// Encoder param limits. Effort is clamped further for each format on export.
+	maxEffort         = 10
+	maxPNGCompression = 9
+
+	formatAuto        = "auto"
+	formatICO         = "ico"
+	dprAuto           = "auto"
+	qualityAuto       = "auto"
+	radiusCircleValue = "circle"
+	frameMiddleValue  = "middle"
+	chromaAuto        = "auto"
+	chroma420         = "420"
+	chroma444         = "444"
+
+	defaultDPR = 1.0
+	maxDPR     = 4.0
+)
+
+var (
+	validChromaValues = []string{chromaAuto, chroma420, chroma444}
+	validBitDepths    = []int{8, 10, 12}
 
-	formatAuto = "auto"
+	// libvips can't limit a PNG palette to an arbitrary number of colours, only
+	// to a bit depth: 2, 4, 16 or 256 colours.
+	validPaletteDepths = []int{1, 2, 4, 8}
+
+	// Params which can be given server-wide defaults with MEDIATOR_ENCODER_DEFAULTS.
+	encoderParams = []string{
+		ParamLossless, ParamNearLossless, ParamEffort, ParamProgressive, ParamChroma,
+		ParamBitDepth, ParamPalette, ParamPaletteDepth, ParamCompression,
+	}
 )
 
 func NewImageOptionsFromRequest(r *http.Request) *ImageOptions {
@@ -46,29 +237,322 @@ func NewImageOptionsFromRequest(r *http.Request) *ImageOptions {
 	width := getQueryParamIntWithDefault(ParamWidth, 0, r)
 	height := getQueryParamIntWithDefault(ParamHeight, 0, r)
 	quality := getQueryParamIntWithDefault(ParamQuality, defaultQuality, r)
+	requestedQuality := getQueryParamWithDefault(ParamQuality, "", r)
+	maxBytes := max(0, getQueryParamIntWithDefault(ParamMaxBytes, 0, r))
 	stripMetadata := getQueryParamBoolWithDefault(ParamStripMetadata, defaultStripMetadata, r)
+	autoRotate := getQueryParamBoolWithDefault(ParamAutoRotate, defaultAutoRotate, r)
 	format := getQueryParamWithDefault(ParamFormat, "", r)
 	pixelateFactor := getQueryParamIntWithDefault(ParamPixelateFactor, defaultPixelateFactor, r)
 	page := getQueryParamIntWithDefault("page", defaultPage, r)
+	gravity := strings.ToLower(getQueryParamWithDefault(ParamGravity, defaultGravity, r))
+
+	if !isValidGravity(gravity) {
+		gravity = defaultGravity
+	}
+
+	focalPointX, focalPointY, ok := parseFocalPoint(getQueryParamWithDefault(ParamFocalPoint, "", r))
+	if !ok {
+		focalPointX, focalPointY = focalPointForGravity(gravity)
+	}
+
+	crop, _ := parseCropRect(getQueryParamWithDefault(ParamCrop, "", r))
+	angle := getQueryParamFloatWithDefault(ParamAngle, 0, r)
+
+	blurSigma := clampFloat(getQueryParamFloatWithDefault(ParamBlurSigma, defaultBlurSigma, r), 0, maxBlurSigma)
+	sharpenSigma := clampFloat(getQueryParamFloatWithDefault(ParamSharpenSigma, defaultSharpenSigma, r), 0, maxSharpenSigma)
+	sharpenFlat := clampFloat(getQueryParamFloatWithDefault(ParamSharpenFlat, defaultSharpenFlat, r), 0, maxSharpenFlat)
+	sharpenJagged := clampFloat(getQueryParamFloatWithDefault(ParamSharpenJagged, defaultSharpenJagged, r), 0, maxSharpenJagged)
+
+	trimThreshold := clampFloat(getQueryParamFloatWithDefault(ParamTrimThreshold, defaultTrimThreshold, r), 0, 255)
+
+	brightness := clampFloat(getQueryParamFloatWithDefault(ParamBrightness, defaultBrightness, r), 0, maxToneMultiplier)
+	contrast := clampFloat(getQueryParamFloatWithDefault(ParamContrast, defaultContrast, r), 0, maxToneMultiplier)
+	saturation := clampFloat(getQueryParamFloatWithDefault(ParamSaturation, defaultSaturation, r), 0, maxToneMultiplier)
+	gamma := clampFloat(getQueryParamFloatWithDefault(ParamGamma, defaultGamma, r), minGamma, maxGamma)
+
+	var tint *vips.ColorRGBA
+	if tintColor, ok := parseHexColor(getQueryParamWithDefault(ParamTint, "", r)); ok {
+		tint = &tintColor
+	}
+
+	duotone, _ := parseDuotone(getQueryParamWithDefault(ParamDuotone, "", r))
+
+	radiusCircle := getQueryParamWithDefault(ParamRadius, "", r) == radiusCircleValue
+	radius := max(0, getQueryParamIntWithDefault(ParamRadius, 0, r))
+
+	watermark := getQueryParamWithDefault(ParamWatermark, "", r)
+	watermarkGravity := strings.ToLower(getQueryParamWithDefault(ParamWatermarkGravity, defaultWatermarkGravity, r))
+	watermarkMargin := max(0, getQueryParamIntWithDefault(ParamWatermarkMargin, defaultWatermarkMargin, r))
+	watermarkOpacity := clampFloat(getQueryParamFloatWithDefault(ParamWatermarkOpacity, defaultWatermarkOpacity, r), 0, 1)
+	watermarkScale := clampFloat(getQueryParamFloatWithDefault(ParamWatermarkScale, defaultWatermarkScale, r), 0, 1)
+
+	if !isValidGravity(watermarkGravity) {
+		watermarkGravity = defaultWatermarkGravity
+	}
+
+	background, ok := parseHexColor(getQueryParamWithDefault(ParamBackground, defaultBackground, r))
+	if !ok {
+		background, _ = parseHexColor(defaultBackground)
+	}
+
+	// strip is kept for compatibility: it maps to meta=none or meta=all.
+	defaultMeta := metaAll
+	if stripMetadata {
+		defaultMeta = metaNone
+	}
+	meta := strings.ToLower(getQueryParamWithDefault(ParamMeta, defaultMeta, r))
+	if !isValidMeta(meta) {
+		meta = defaultMeta
+	}
+	stripMetadata = meta == metaNone
+
+	defaultICC := iccKeep
+	if stripMetadata {
+		defaultICC = iccSRGB
+	}
+	icc := strings.ToLower(getQueryParamWithDefault(ParamICC, defaultICC, r))
+	if !isValidICC(icc) {
+		icc = defaultICC
+	}
+
+	lossless := getQueryParamBoolWithDefault(ParamLossless, false, r)
+	nearLossless := getQueryParamBoolWithDefault(ParamNearLossless, false, r)
+	effort := clampInt(getQueryParamIntWithDefault(ParamEffort, 0, r), 0, maxEffort)
+
+	var progressive *bool
+	if value, ok := getQueryParamBool(ParamProgressive, r); ok {
+		progressive = &value
+	}
 
-	var imageType vips.ImageType
+	chroma := getQueryParamWithDefault(ParamChroma, "", r)
+	if !slices.Contains(validChromaValues, chroma) {
+		chroma = ""
+	}
+
+	bitDepth := getQueryParamIntWithDefault(ParamBitDepth, 0, r)
+	if !slices.Contains(validBitDepths, bitDepth) {
+		bitDepth = 0
+	}
 
-	if format == formatAuto {
-		imageType = ImageTypeFromAccept(r.Header.Get("Accept"))
-	} else {
-		imageType = ImageType(format)
+	palette := getQueryParamBoolWithDefault(ParamPalette, false, r)
+	paletteDepth := getQueryParamIntWithDefault(ParamPaletteDepth, 0, r)
+	if !palette || !slices.Contains(validPaletteDepths, paletteDepth) {
+		paletteDepth = 0
 	}
 
-	return &ImageOptions{
-		Operations:      operations,
-		Width:           width,
-		Height:          height,
-		Quality:         quality,
-		StripMetadata:   stripMetadata,
-		Format:          imageType,
-		RequestedFormat: format,
-		AutoRotate:      stripMetadata,
-		PixelateFactor:  pixelateFactor,
-		Page:            page,
+	var compression *int
+	if value, ok := getQueryParamInt(ParamCompression, r); ok {
+		value = clampInt(value, 0, maxPNGCompression)
+		compression = &value
+	}
+
+	animated := getQueryParamBoolWithDefault(ParamAnimated, false, r)
+	frameMiddle := getQueryParamWithDefault(ParamFrame, "", r) == frameMiddleValue
+
+	var frame *int
+	if value, ok := getQueryParamInt(ParamFrame, r); ok && value >= 0 {
+		frame = &value
+	}
+
+	requestedDPR := getQueryParamWithDefault(ParamDPR, "", r)
+	dpr := dprFromRequest(requestedDPR, r)
+
+	lqip := getQueryParamBoolWithDefault(ParamLQIP, false, r)
+	if lqip {
+		// Placeholders are upscaled by the browser anyway, so the response
+		// doesn't depend on the DPR client hints either.
+		dpr, requestedDPR = defaultDPR, ""
+		if _, ok := getQueryParam(ParamBlurSigma, r); !ok {
+			blurSigma = defaultLQIPBlurSigma
+		}
 	}
+
+	output := strings.ToLower(getQueryParamWithDefault(ParamOutput, "", r))
+	if !isValidOutput(output) {
+		output = ""
+	}
+
+	imageType := ImageType(format)
+	ico := strings.EqualFold(format, formatICO)
+	if ico {
+		imageType = vips.ImageTypePNG
+	}
+
+	imageOptions := &ImageOptions{
+		Operations:       operations,
+		Width:            width,
+		Height:           height,
+		Quality:          quality,
+		RequestedQuality: requestedQuality,
+		AutoQuality:      requestedQuality == qualityAuto,
+		MaxBytes:         maxBytes,
+		StripMetadata:    stripMetadata,
+		Meta:             meta,
+		Format:           imageType,
+		RequestedFormat:  format,
+		ICO:              ico,
+		AutoRotate:       autoRotate,
+		ICC:              icc,
+		PixelateFactor:   pixelateFactor,
+		Page:             page,
+		Gravity:          gravity,
+		FocalPointX:      focalPointX,
+		FocalPointY:      focalPointY,
+		Crop:             crop,
+		Angle:            angle,
+		Background:       background,
+		BlurSigma:        blurSigma,
+		SharpenSigma:     sharpenSigma,
+		SharpenFlat:      sharpenFlat,
+		SharpenJagged:    sharpenJagged,
+		TrimThreshold:    trimThreshold,
+		Brightness:       brightness,
+		Contrast:         contrast,
+		Saturation:       saturation,
+		Gamma:            gamma,
+		Tint:             tint,
+		Duotone:          duotone,
+		Radius:           radius,
+		RadiusCircle:     radiusCircle,
+
+		Watermark:        watermark,
+		WatermarkGravity: watermarkGravity,
+		WatermarkMargin:  watermarkMargin,
+		WatermarkOpacity: watermarkOpacity,
+		WatermarkScale:   watermarkScale,
+
+		DPR:          dpr,
+		RequestedDPR: requestedDPR,
+
+		Lossless:     lossless,
+		NearLossless: nearLossless,
+		Effort:       effort,
+		Progressive:  progressive,
+		Chroma:       chroma,
+		BitDepth:     bitDepth,
+		Palette:      palette,
+		PaletteDepth: paletteDepth,
+		Compression:  compression,
+
+		Animated:    animated,
+		Frame:       frame,
+		FrameMiddle: frameMiddle,
+
+		LQIP:   lqip,
+		Output: output,
+	}
+
+	imageOptions.negotiateFormat(r.Header.Get("Accept"), nil)
+	imageOptions.scaleForDPR()
+	imageOptions.applyLQIP()
+
+	return imageOptions
+}
+
+// dprFromRequest returns the device pixel ratio from the dpr param, or from the
+// client hint headers when the param is "auto".
+func dprFromRequest(requestedDPR string, r *http.Request) float64 {
+	value := requestedDPR
+
+	if requestedDPR == dprAuto {
+		value = r.Header.Get("Sec-CH-DPR")
+		if value == "" {
+			value = r.Header.Get("DPR")
+		}
+	}
+
+	dpr, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(dpr) {
+		return defaultDPR
+	}
+
+	return clampFloat(dpr, defaultDPR, maxDPR)
+}
+
+// negotiateFormat resolves format=auto to the most preferred format the
+// client accepts, trying preferences (defaultAutoFormats when empty) in order.
+func (o *ImageOptions) negotiateFormat(accept string, preferences []vips.ImageType) {
+	if o.RequestedFormat != formatAuto {
+		return
+	}
+
+	if len(preferences) == 0 {
+		preferences = defaultAutoFormats
+	}
+
+	if o.Animated {
+		preferences = animatedImageTypes(preferences)
+	}
+
+	o.Format = NegotiateImageType(accept, preferences)
+}
+
+// applyFormatQuality sets the configured default quality for the output
+// format, unless the request asked for a specific quality.
+func (o *ImageOptions) applyFormatQuality() {
+	if o.RequestedQuality != "" || o.LQIP {
+		return
+	}
+
+	if quality, exists := o.FormatQualities[o.Format]; exists {
+		o.Quality = quality
+	}
+}
+
+// scaleForDPR multiplies the target size and pixel-based operation params, so
+// that e.g. w=300&dpr=2 produces the same image as w=600.
+func (o *ImageOptions) scaleForDPR() {
+	if o.DPR == defaultDPR {
+		return
+	}
+
+	o.scalePixelParams(o.DPR)
+	o.BlurSigma = math.Min(o.BlurSigma*o.DPR, maxBlurSigma)
+	o.SharpenSigma = math.Min(o.SharpenSigma*o.DPR, maxSharpenSigma)
+}
+
+// scalePixelParams multiplies the target size and the params given in pixels.
+// Unset (0) values stay unset, others are kept at 1 px at least.
+func (o *ImageOptions) scalePixelParams(factor float64) {
+	scale := func(value int) int {
+		if value == 0 {
+			return 0
+		}
+		return max(1, int(math.Round(float64(value)*factor)))
+	}
+
+	o.Width = scale(o.Width)
+	o.Height = scale(o.Height)
+	o.Radius = scale(o.Radius)
+	o.PixelateFactor = scale(o.PixelateFactor)
+	o.WatermarkMargin = scale(o.WatermarkMargin)
+}
+
+// parseCropRect parses "x,y,w,h". Values with a fractional part (e.g. "0.25")
+// make the whole rectangle relative to the source image size.
+func parseCropRect(value string) (CropRect, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return CropRect{}, false
+	}
+
+	var values [4]float64
+	relative := false
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return CropRect{}, false
+		}
+
+		if strings.Contains(part, ".") {
+			relative = true
+		}
+
+		values[i] = v
+	}
+
+	return CropRect{X: values[0], Y: values[1], Width: values[2], Height: values[3], Relative: relative}, true
 }
@@ -46,8 +46,11 @@ func TestNewImageOptionsFromRequestParsesValues(t *testing.T) {
 	if opts.StripMetadata {
 		t.Fatalf("StripMetadata = true, want false")
 	}
-	if opts.AutoRotate {
-		t.Fatalf("AutoRotate should follow StripMetadata")
+	if opts.Meta != metaAll {
+		t.Fatalf("Meta = %q, want all", opts.Meta)
+	}
+	if !opts.AutoRotate {
+		t.Fatalf("AutoRotate should not depend on StripMetadata")
 	}
 	if opts.RequestedFormat != "auto" {
 		t.Fatalf("RequestedFormat = %q", opts.RequestedFormat)
@@ -59,3 +62,342 @@ func TestNewImageOptionsFromRequestParsesValues(t *testing.T) {
 		t.Fatalf("pixelate/page = %d/%d", opts.PixelateFactor, opts.Page)
 	}
 }
+
+func TestNewImageOptionsFromRequestFocalPoint(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?op=fill&gravity=south-east", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.Gravity != "south-east" || opts.FocalPointX != 1 || opts.FocalPointY != 1 {
+		t.Fatalf("gravity/fp = %q %v,%v", opts.Gravity, opts.FocalPointX, opts.FocalPointY)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?op=fill&gravity=north&fp=0.3,0.7", nil)
+	opts = NewImageOptionsFromRequest(req)
+
+	if opts.FocalPointX != 0.3 || opts.FocalPointY != 0.7 {
+		t.Fatalf("fp should override gravity, got %v,%v", opts.FocalPointX, opts.FocalPointY)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?op=fill&gravity=sideways", nil)
+	opts = NewImageOptionsFromRequest(req)
+
+	if opts.Gravity != defaultGravity || opts.FocalPointX != 0.5 || opts.FocalPointY != 0.5 {
+		t.Fatalf("invalid gravity should fall back to center, got %q %v,%v", opts.Gravity, opts.FocalPointX, opts.FocalPointY)
+	}
+}
+
+func TestParseCropRect(t *testing.T) {
+	rect, ok := parseCropRect("10,20,100,50")
+	if !ok || rect != (CropRect{X: 10, Y: 20, Width: 100, Height: 50}) {
+		t.Fatalf("parseCropRect(pixels) = %+v, %v", rect, ok)
+	}
+
+	rect, ok = parseCropRect("0.1,0,0.5,1")
+	if !ok || !rect.Relative || rect.X != 0.1 || rect.Width != 0.5 {
+		t.Fatalf("parseCropRect(relative) = %+v, %v", rect, ok)
+	}
+
+	for _, value := range []string{"", "1,2,3", "a,b,c,d", "-1,0,10,10"} {
+		if _, ok := parseCropRect(value); ok {
+			t.Fatalf("parseCropRect(%q) should fail", value)
+		}
+	}
+}
+
+func TestNewImageOptionsFromRequestRotation(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?op=rotate&angle=22.5&bg=ff000080", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.Angle != 22.5 {
+		t.Fatalf("Angle = %v", opts.Angle)
+	}
+	if opts.Background != (vips.ColorRGBA{R: 255, A: 128}) {
+		t.Fatalf("Background = %v", opts.Background)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?op=rotate&angle=90&bg=nope", nil)
+	opts = NewImageOptionsFromRequest(req)
+
+	if opts.Background != (vips.ColorRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("invalid bg should fall back to white, got %v", opts.Background)
+	}
+}
+
+func TestNewImageOptionsFromRequestClampsConvolutionParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?op=blur,sharpen&blursigma=100000&sharpensigma=500&sharpenflat=-1&sharpenjagged=1e9", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.BlurSigma != maxBlurSigma {
+		t.Fatalf("BlurSigma = %v, want %v", opts.BlurSigma, maxBlurSigma)
+	}
+	if opts.SharpenSigma != maxSharpenSigma {
+		t.Fatalf("SharpenSigma = %v, want %v", opts.SharpenSigma, maxSharpenSigma)
+	}
+	if opts.SharpenFlat != 0 || opts.SharpenJagged != maxSharpenJagged {
+		t.Fatalf("SharpenFlat/SharpenJagged = %v/%v", opts.SharpenFlat, opts.SharpenJagged)
+	}
+}
+
+func TestNewImageOptionsFromRequestWatermark(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?op=fit,watermark&wm=logos/logo.png&wmgravity=north-west&wmmargin=-5&wmopacity=1.5&wmscale=0.1", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.Watermark != "logos/logo.png" || opts.WatermarkGravity != "north-west" {
+		t.Fatalf("watermark = %q %q", opts.Watermark, opts.WatermarkGravity)
+	}
+	if opts.WatermarkMargin != 0 || opts.WatermarkOpacity != 1 || opts.WatermarkScale != 0.1 {
+		t.Fatalf("margin/opacity/scale = %d/%v/%v", opts.WatermarkMargin, opts.WatermarkOpacity, opts.WatermarkScale)
+	}
+}
+
+func TestNewImageOptionsFromRequestToneParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?op=brightness,contrast&brightness=1.2&contrast=50&saturation=0.5&gamma=0&tint=f00&duotone=000000,ffffff", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.Brightness != 1.2 || opts.Contrast != maxToneMultiplier || opts.Saturation != 0.5 {
+		t.Fatalf("brightness/contrast/saturation = %v/%v/%v", opts.Brightness, opts.Contrast, opts.Saturation)
+	}
+	if opts.Gamma != minGamma {
+		t.Fatalf("Gamma = %v, want %v", opts.Gamma, minGamma)
+	}
+	if opts.Tint == nil || *opts.Tint != (vips.ColorRGBA{R: 255, A: 255}) {
+		t.Fatalf("Tint = %v", opts.Tint)
+	}
+	if opts.Duotone == nil {
+		t.Fatalf("Duotone = nil")
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/", nil))
+	if opts.Brightness != 1 || opts.Contrast != 1 || opts.Saturation != 1 || opts.Gamma != 1 || opts.Tint != nil || opts.Duotone != nil {
+		t.Fatalf("tone defaults = %+v", opts)
+	}
+}
+
+func TestNewImageOptionsFromRequestRadius(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?op=round&radius=12", nil))
+	if opts.Radius != 12 || opts.RadiusCircle {
+		t.Fatalf("radius = %d/%v", opts.Radius, opts.RadiusCircle)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?op=round&radius=circle", nil))
+	if opts.Radius != 0 || !opts.RadiusCircle {
+		t.Fatalf("radius = %d/%v", opts.Radius, opts.RadiusCircle)
+	}
+}
+
+func TestNewImageOptionsFromRequestDPR(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?w=300&h=200&dpr=2&radius=8&blursigma=3&wmmargin=10&pixelatefactor=5", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if opts.DPR != 2 || opts.Width != 600 || opts.Height != 400 {
+		t.Fatalf("dpr/size = %v %dx%d", opts.DPR, opts.Width, opts.Height)
+	}
+	if opts.Radius != 16 || opts.BlurSigma != 6 || opts.WatermarkMargin != 20 || opts.PixelateFactor != 10 {
+		t.Fatalf("radius/blur/margin/pixelate = %d/%v/%d/%d", opts.Radius, opts.BlurSigma, opts.WatermarkMargin, opts.PixelateFactor)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?w=100&dpr=10", nil))
+	if opts.DPR != maxDPR || opts.Width != 400 {
+		t.Fatalf("dpr should be clamped, got %v (w=%d)", opts.DPR, opts.Width)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?w=100&dpr=nope", nil))
+	if opts.DPR != 1 || opts.Width != 100 {
+		t.Fatalf("invalid dpr should fall back to 1, got %v (w=%d)", opts.DPR, opts.Width)
+	}
+}
+
+func TestNewImageOptionsFromRequestDPRClientHints(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?w=100&dpr=auto", nil)
+	req.Header.Set("Sec-CH-DPR", "1.5")
+	req.Header.Set("DPR", "3")
+
+	opts := NewImageOptionsFromRequest(req)
+	if opts.DPR != 1.5 || opts.Width != 150 || opts.RequestedDPR != "auto" {
+		t.Fatalf("dpr/w = %v/%d", opts.DPR, opts.Width)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?w=100&dpr=auto", nil)
+	req.Header.Set("DPR", "3")
+
+	opts = NewImageOptionsFromRequest(req)
+	if opts.DPR != 3 || opts.Width != 300 {
+		t.Fatalf("legacy DPR header: dpr/w = %v/%d", opts.DPR, opts.Width)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?w=100&dpr=auto", nil)
+	opts = NewImageOptionsFromRequest(req)
+	if opts.DPR != 1 || opts.Width != 100 {
+		t.Fatalf("no client hints: dpr/w = %v/%d", opts.DPR, opts.Width)
+	}
+}
+
+func TestNewImageOptionsFromRequestAnimated(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?w=100&animated=true&format=auto", nil)
+	req.Header.Set("Accept", "image/avif,image/webp,image/*")
+
+	opts := NewImageOptionsFromRequest(req)
+	if !opts.Animated || opts.Format != vips.ImageTypeWEBP {
+		t.Fatalf("animated/format = %v/%v", opts.Animated, opts.Format)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/?w=100&animated=true&format=auto", nil)
+	req.Header.Set("Accept", "image/avif,image/*")
+
+	opts = NewImageOptionsFromRequest(req)
+	if opts.Format != vips.ImageTypeGIF {
+		t.Fatalf("format without webp support = %v, want gif", opts.Format)
+	}
+}
+
+func TestNewImageOptionsFromRequestFrame(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?frame=3", nil))
+	if opts.Frame == nil || *opts.Frame != 3 || opts.FrameMiddle {
+		t.Fatalf("frame = %v/%v", opts.Frame, opts.FrameMiddle)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?frame=middle", nil))
+	if opts.Frame != nil || !opts.FrameMiddle {
+		t.Fatalf("frame = %v/%v", opts.Frame, opts.FrameMiddle)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?frame=-2", nil))
+	if opts.Frame != nil || opts.FrameMiddle {
+		t.Fatalf("negative frame should be ignored, got %v/%v", opts.Frame, opts.FrameMiddle)
+	}
+}
+
+func TestNewImageOptionsFromRequestEncoderParams(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?lossless=true&effort=4", nil))
+	if !opts.Lossless || opts.Effort != 4 {
+		t.Fatalf("lossless/effort = %v/%d", opts.Lossless, opts.Effort)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?effort=-3", nil))
+	if opts.Lossless || opts.Effort != 0 {
+		t.Fatalf("lossless/effort = %v/%d", opts.Lossless, opts.Effort)
+	}
+}
+
+func TestNewImageOptionsFromRequestFormatEncoderParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/?nearlossless=true&progressive=false&chroma=444&bitdepth=10&palette=true&palettedepth=4&compression=42&effort=99", nil)
+	opts := NewImageOptionsFromRequest(req)
+
+	if !opts.NearLossless || opts.Progressive == nil || *opts.Progressive {
+		t.Fatalf("nearlossless/progressive = %v/%v", opts.NearLossless, opts.Progressive)
+	}
+	if opts.Chroma != chroma444 || opts.BitDepth != 10 {
+		t.Fatalf("chroma/bitdepth = %q/%d", opts.Chroma, opts.BitDepth)
+	}
+	if !opts.Palette || opts.PaletteDepth != 4 {
+		t.Fatalf("palette/palettedepth = %v/%d", opts.Palette, opts.PaletteDepth)
+	}
+	if opts.Compression == nil || *opts.Compression != maxPNGCompression || opts.Effort != maxEffort {
+		t.Fatalf("compression/effort = %v/%d", opts.Compression, opts.Effort)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?chroma=422&bitdepth=9", nil))
+	if opts.Chroma != "" || opts.BitDepth != 0 || opts.Progressive != nil || opts.Compression != nil {
+		t.Fatalf("invalid values should be ignored, got %q/%d/%v/%v", opts.Chroma, opts.BitDepth, opts.Progressive, opts.Compression)
+	}
+
+	for _, query := range []string{"palettedepth=4", "palette=true&palettedepth=3"} {
+		opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?"+query, nil))
+		if opts.PaletteDepth != 0 {
+			t.Fatalf("%s: PaletteDepth = %d, want it ignored", query, opts.PaletteDepth)
+		}
+	}
+}
+
+func TestApplyFormatQuality(t *testing.T) {
+	qualities := map[vips.ImageType]int{vips.ImageTypeAVIF: 50}
+
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?format=avif", nil))
+	opts.FormatQualities = qualities
+	opts.applyFormatQuality()
+	if opts.Quality != 50 {
+		t.Fatalf("quality = %d, want the AVIF default", opts.Quality)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?format=avif&q=80", nil))
+	opts.FormatQualities = qualities
+	opts.applyFormatQuality()
+	if opts.Quality != 80 {
+		t.Fatalf("quality = %d, requested quality should win", opts.Quality)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?format=jpeg", nil))
+	opts.FormatQualities = qualities
+	opts.applyFormatQuality()
+	if opts.Quality != defaultQuality {
+		t.Fatalf("quality = %d, want %d", opts.Quality, defaultQuality)
+	}
+}
+
+func TestNewImageOptionsFromRequestAutoQuality(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?q=auto", nil))
+	if !opts.AutoQuality || opts.Quality != defaultQuality || opts.RequestedQuality != "auto" {
+		t.Fatalf("auto quality = %v/%d/%q", opts.AutoQuality, opts.Quality, opts.RequestedQuality)
+	}
+}
+
+func TestNewImageOptionsFromRequestICO(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?format=ico", nil))
+	if !opts.ICO || opts.Format != vips.ImageTypePNG {
+		t.Fatalf("ico = %v/%v", opts.ICO, opts.Format)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?format=png", nil))
+	if opts.ICO {
+		t.Fatal("ICO should be set for format=ico only")
+	}
+}
+
+func TestNewImageOptionsFromRequestMeta(t *testing.T) {
+	cases := []struct {
+		query     string
+		wantMeta  string
+		wantStrip bool
+	}{
+		{"", metaNone, true},
+		{"strip=false", metaAll, false},
+		{"meta=copyright", metaCopyright, false},
+		{"meta=ALL&strip=true", metaAll, false},
+		{"meta=none&strip=false", metaNone, true},
+		{"meta=bogus&strip=false", metaAll, false},
+	}
+
+	for _, tc := range cases {
+		opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?"+tc.query, nil))
+		if opts.Meta != tc.wantMeta || opts.StripMetadata != tc.wantStrip {
+			t.Fatalf("%q: meta/strip = %q/%v, want %q/%v", tc.query, opts.Meta, opts.StripMetadata, tc.wantMeta, tc.wantStrip)
+		}
+	}
+
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?autorotate=false", nil))
+	if opts.AutoRotate || !opts.StripMetadata {
+		t.Fatalf("autorotate/strip = %v/%v, want false/true", opts.AutoRotate, opts.StripMetadata)
+	}
+}
+
+func TestNewImageOptionsFromRequestICC(t *testing.T) {
+	opts := NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/", nil))
+	if opts.ICC != iccSRGB {
+		t.Fatalf("icc = %q, want srgb when stripping", opts.ICC)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?strip=false", nil))
+	if opts.ICC != iccKeep {
+		t.Fatalf("icc = %q, want keep without stripping", opts.ICC)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?icc=KEEP", nil))
+	if opts.ICC != iccKeep {
+		t.Fatalf("icc = %q, want keep", opts.ICC)
+	}
+
+	opts = NewImageOptionsFromRequest(httptest.NewRequest("GET", "http://example.com/?icc=bogus", nil))
+	if opts.ICC != iccSRGB {
+		t.Fatalf("icc = %q, invalid value should fall back to the default", opts.ICC)
+	}
+}
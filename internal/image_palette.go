@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+const (
+	// Size of the image copy palettes are computed on, in pixels.
+	paletteSampleSize    = 64
+	defaultPaletteColors = 5
+	maxExtractedColors   = 16
+	paletteIterations    = 4
+)
+
+// ImagePalette is the result of the /image/palette endpoint.
+type ImagePalette struct {
+	// Dominant is the most common colour, as a CSS hex colour.
+	Dominant string         `json:"dominant"`
+	Colors   []PaletteColor `json:"colors"`
+}
+
+type PaletteColor struct {
+	Hex string `json:"hex"`
+	RGB [3]int `json:"rgb"`
+	// Share is the fraction of (opaque) pixels represented by the colour.
+	Share float64 `json:"share"`
+}
+
+// NewImagePalette extracts up to n colours from the image, ordered by share.
+func NewImagePalette(imageBytes []byte, n int) (*ImagePalette, error) {
+	pixels, _, _, err := loadPreviewPixels(imageBytes, paletteSampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	colors := extractPalette(pixels, n)
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+
+	return &ImagePalette{
+		Dominant: colors[0].Hex,
+		Colors:   colors,
+	}, nil
+}
+
+type colorBox [][3]uint8
+
+// channelRange returns the channel with the widest range of values, and the
+// range.
+func (b colorBox) channelRange() (int, int) {
+	channel, widest := 0, -1
+
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, p := range b {
+			lo = min(lo, int(p[c]))
+			hi = max(hi, int(p[c]))
+		}
+
+		if hi-lo > widest {
+			channel, widest = c, hi-lo
+		}
+	}
+
+	return channel, widest
+}
+
+func (b colorBox) average() [3]int {
+	var sum [3]int
+	for _, p := range b {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+
+	var avg [3]int
+	for c := 0; c < 3; c++ {
+		avg[c] = int(math.Round(float64(sum[c]) / float64(len(b))))
+	}
+
+	return avg
+}
+
+// extractPalette quantizes RGBA pixels to at most n colours with median cut.
+// Mostly transparent pixels are ignored, unless there's nothing else.
+func extractPalette(pixels []byte, n int) []PaletteColor {
+	var opaque, all colorBox
+	for i := 0; i+3 < len(pixels); i += 4 {
+		p := [3]uint8{pixels[i], pixels[i+1], pixels[i+2]}
+		all = append(all, p)
+		if pixels[i+3] >= 128 {
+			opaque = append(opaque, p)
+		}
+	}
+
+	box := opaque
+	if len(box) == 0 {
+		box = all
+	}
+	if len(box) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{box}
+	for len(boxes) < n {
+		// Split the box with the widest channel range; weighting by the number
+		// of pixels keeps rare outliers from taking over the palette.
+		split, best := -1, 0.0
+		for i, b := range boxes {
+			_, width := b.channelRange()
+			if score := float64(width) * math.Sqrt(float64(len(b))); len(b) > 1 && width > 0 && score > best {
+				split, best = i, score
+			}
+		}
+
+		if split < 0 {
+			break
+		}
+
+		b := boxes[split]
+		channel, _ := b.channelRange()
+		sort.Slice(b, func(i, j int) bool { return b[i][channel] < b[j][channel] })
+
+		median := len(b) / 2
+		boxes = slices.Replace(boxes, split, split+1, b[:median], b[median:])
+	}
+
+	centers := make([][3]int, len(boxes))
+	for i, b := range boxes {
+		centers[i] = b.average()
+	}
+
+	// Median cut splits boxes in halves, so their sizes say little about how
+	// common a colour is: refine the centers with a few k-means iterations and
+	// count the pixels closest to each instead.
+	counts := make([]int, len(centers))
+	for iteration := 0; iteration < paletteIterations; iteration++ {
+		sums := make([][3]int, len(centers))
+		clear(counts)
+
+		for _, p := range box {
+			nearest := nearestColor(centers, p)
+			counts[nearest]++
+			for c := 0; c < 3; c++ {
+				sums[nearest][c] += int(p[c])
+			}
+		}
+
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				centers[i][c] = int(math.Round(float64(sums[i][c]) / float64(counts[i])))
+			}
+		}
+	}
+
+	colors := make([]PaletteColor, 0, len(centers))
+	for i, rgb := range centers {
+		if counts[i] == 0 {
+			continue
+		}
+
+		colors = append(colors, PaletteColor{
+			Hex:   fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]),
+			RGB:   rgb,
+			Share: math.Round(float64(counts[i])/float64(len(box))*1000) / 1000,
+		})
+	}
+
+	sort.SliceStable(colors, func(i, j int) bool { return colors[i].Share > colors[j].Share })
+
+	return colors
+}
+
+func nearestColor(centers [][3]int, p [3]uint8) int {
+	nearest, best := 0, math.MaxInt
+	for i, center := range centers {
+		dr, dg, db := center[0]-int(p[0]), center[1]-int(p[1]), center[2]-int(p[2])
+		if distance := dr*dr + dg*dg + db*db; distance < best {
+			nearest, best = i, distance
+		}
+	}
+
+	return nearest
+}
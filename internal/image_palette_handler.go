@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// ImagePaletteHandler returns the dominant colour and palette of source images
+// as ImagePalette JSON. The number of colours is set with the colors param.
+type ImagePaletteHandler struct {
+	transforms *ImageTransformHandler
+}
+
+func NewImagePaletteHandler(transforms *ImageTransformHandler) *ImagePaletteHandler {
+	return &ImagePaletteHandler{transforms}
+}
+
+func (h *ImagePaletteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	imageSource := getImageSource(r.Context())
+	colors := clampInt(getQueryParamIntWithDefault(ParamColors, defaultPaletteColors, r), 1, maxExtractedColors)
+
+	if !h.transforms.acquire(w, r) {
+		return
+	}
+	defer h.transforms.release()
+
+	downloadedFile, _, ok := h.transforms.downloadImage(w, imageSource)
+	if !ok {
+		return
+	}
+
+	palette, err := NewImagePalette(downloadedFile.Buffer.Bytes(), colors)
+	if err != nil {
+		slog.Error("Image palette error", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", h.transforms.config.CacheControl)
+	writeJSONResponse(w, palette)
+}
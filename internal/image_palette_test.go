@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func rgbaPixels(counts map[color.RGBA]int) []byte {
+	var pixels []byte
+	for c, n := range counts {
+		for i := 0; i < n; i++ {
+			pixels = append(pixels, c.R, c.G, c.B, c.A)
+		}
+	}
+	return pixels
+}
+
+func TestExtractPalette(t *testing.T) {
+	red := color.RGBA{220, 20, 20, 255}
+	blue := color.RGBA{20, 20, 220, 255}
+	transparent := color.RGBA{0, 255, 0, 0}
+
+	colors := extractPalette(rgbaPixels(map[color.RGBA]int{red: 70, blue: 30, transparent: 200}), 4)
+	if len(colors) != 2 {
+		t.Fatalf("colors = %+v, want red and blue", colors)
+	}
+	if colors[0].Hex != "#dc1414" || colors[0].Share != 0.7 {
+		t.Fatalf("dominant = %+v, want #dc1414 with 0.7 share", colors[0])
+	}
+	if colors[1].Hex != "#1414dc" || colors[1].RGB != [3]int{20, 20, 220} {
+		t.Fatalf("second = %+v, want #1414dc", colors[1])
+	}
+
+	if colors := extractPalette(rgbaPixels(map[color.RGBA]int{red: 10, blue: 10}), 1); len(colors) != 1 || colors[0].Share != 1 {
+		t.Fatalf("single colour palette = %+v", colors)
+	}
+
+	if colors := extractPalette(rgbaPixels(map[color.RGBA]int{transparent: 10}), 3); len(colors) != 1 || colors[0].Hex != "#00ff00" {
+		t.Fatalf("transparent palette = %+v", colors)
+	}
+
+	if colors := extractPalette(nil, 3); colors != nil {
+		t.Fatalf("empty palette = %+v", colors)
+	}
+}
+
+func TestImagePaletteHandler(t *testing.T) {
+	src := makeSplitPNG(t, 100, 40, color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255})
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImagePaletteHandler(NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	}))
+
+	req := httptest.NewRequest("GET", "http://example.com/image/palette/src/img?colors=3", nil)
+	req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%q", rr.Code, rr.Body.String())
+	}
+
+	var palette ImagePalette
+	if err := json.Unmarshal(rr.Body.Bytes(), &palette); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(palette.Colors) == 0 || len(palette.Colors) > 3 || palette.Dominant != palette.Colors[0].Hex {
+		t.Fatalf("palette = %+v", palette)
+	}
+
+	// Resampling blends the edge between the halves, so allow for some
+	// intermediate colours.
+	var white, black bool
+	for _, c := range palette.Colors {
+		white = white || (c.RGB[0] >= 245 && c.RGB[1] >= 245 && c.RGB[2] >= 245)
+		black = black || (c.RGB[0] <= 10 && c.RGB[1] <= 10 && c.RGB[2] <= 10)
+	}
+	if !white || !black {
+		t.Fatalf("palette = %+v, want white and black", palette)
+	}
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// loadPreviewPixels loads a copy of the image downscaled to fit within
+// size x size (using shrink-on-load, so it's cheap even for large sources) and
+// returns its 8-bit sRGB pixels with alpha, 4 bytes per pixel. It's meant for
+// colour statistics and hashes, which more pixels wouldn't change much.
+func loadPreviewPixels(imageBytes []byte, size int) ([]byte, int, int, error) {
+	image, err := vips.LoadThumbnailFromBuffer(imageBytes, size, size, vips.InterestingNone, vips.SizeDown, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer image.Close()
+
+	if err := convertToSRGB(image); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := ensureColorImage(image); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if image.BandFormat() != vips.BandFormatUchar {
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	if !image.HasAlpha() {
+		if err := image.AddAlpha(); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	pixels, err := image.ToBytes()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	width, height := image.Width(), image.Height()
+	if len(pixels) != width*height*4 {
+		return nil, 0, 0, fmt.Errorf("unexpected pixel data: %d bytes for %dx%d", len(pixels), width, height)
+	}
+
+	return pixels, width, height, nil
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Values of the hash param.
+const (
+	placeholderBlurHash  = "blurhash"
+	placeholderThumbHash = "thumbhash"
+)
+
+// Size of the image copy placeholder hashes are computed on, in pixels.
+const placeholderSampleSize = 32
+
+func isValidPlaceholderHash(value string) bool {
+	return value == placeholderBlurHash || value == placeholderThumbHash
+}
+
+// ImagePlaceholder is the JSON result of the /image/placeholder endpoint.
+// Width and Height are the dimensions of the source image, as displayed.
+type ImagePlaceholder struct {
+	Type        string  `json:"type"`
+	Hash        string  `json:"hash"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	AspectRatio float64 `json:"aspectRatio"`
+}
+
+// NewImagePlaceholder computes the BlurHash or ThumbHash of the image.
+// componentsX and componentsY only apply to BlurHash.
+func NewImagePlaceholder(imageBytes []byte, imageType vips.ImageType, hashType string, componentsX, componentsY int) (*ImagePlaceholder, error) {
+	info, err := NewImageInfo(imageBytes, imageType)
+	if err != nil {
+		return nil, err
+	}
+
+	pixels, width, height, err := loadPreviewPixels(imageBytes, placeholderSampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var hash string
+	switch hashType {
+	case placeholderBlurHash:
+		hash, err = encodeBlurHash(pixels, width, height, componentsX, componentsY)
+	case placeholderThumbHash:
+		hash, err = encodeThumbHash(pixels, width, height)
+	default:
+		err = fmt.Errorf("unknown placeholder hash: %s", hashType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImagePlaceholder{
+		Type:        hashType,
+		Hash:        hash,
+		Width:       info.OrientedWidth,
+		Height:      info.OrientedHeight,
+		AspectRatio: math.Round(float64(info.OrientedWidth)/float64(info.OrientedHeight)*10000) / 10000,
+	}, nil
+}
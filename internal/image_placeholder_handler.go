@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ImagePlaceholderHandler returns the BlurHash or ThumbHash of source images,
+// as plain text or (with output=json) as ImagePlaceholder JSON.
+type ImagePlaceholderHandler struct {
+	transforms *ImageTransformHandler
+}
+
+func NewImagePlaceholderHandler(transforms *ImageTransformHandler) *ImagePlaceholderHandler {
+	return &ImagePlaceholderHandler{transforms}
+}
+
+func (h *ImagePlaceholderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	imageSource := getImageSource(r.Context())
+
+	hashType := strings.ToLower(getQueryParamWithDefault(ParamHash, placeholderBlurHash, r))
+	if !isValidPlaceholderHash(hashType) {
+		http.Error(w, "Unsupported hash: "+hashType, http.StatusBadRequest)
+		return
+	}
+
+	componentsX, componentsY, ok := parseBlurHashComponents(getQueryParamWithDefault(ParamComponents, "", r))
+	if !ok {
+		componentsX, componentsY = defaultBlurHashComponentsX, defaultBlurHashComponentsY
+	}
+
+	output := strings.ToLower(getQueryParamWithDefault(ParamOutput, outputText, r))
+
+	if !h.transforms.acquire(w, r) {
+		return
+	}
+	defer h.transforms.release()
+
+	downloadedFile, downloadedImageType, ok := h.transforms.downloadImage(w, imageSource)
+	if !ok {
+		return
+	}
+
+	placeholder, err := NewImagePlaceholder(downloadedFile.Buffer.Bytes(), downloadedImageType, hashType, componentsX, componentsY)
+	if err != nil {
+		slog.Error("Image placeholder error", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", h.transforms.config.CacheControl)
+
+	if output == outputJSON {
+		writeJSONResponse(w, placeholder)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(placeholder.Hash)))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(placeholder.Hash))
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImagePlaceholderHandler(t *testing.T) {
+	src := makeSplitPNG(t, 60, 30, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255})
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImagePlaceholderHandler(NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	}))
+
+	request := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/image/placeholder/src/img?"+query, nil)
+		req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("status = %d, content type = %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if got := rr.Body.Len(); got != 28 {
+		t.Fatalf("blurhash = %q, want 28 characters", rr.Body.String())
+	}
+
+	rr = request("hash=thumbhash&output=json")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%q", rr.Code, rr.Body.String())
+	}
+
+	var placeholder ImagePlaceholder
+	if err := json.Unmarshal(rr.Body.Bytes(), &placeholder); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if placeholder.Type != placeholderThumbHash || placeholder.Hash == "" {
+		t.Fatalf("placeholder = %+v", placeholder)
+	}
+	if placeholder.Width != 60 || placeholder.Height != 30 || placeholder.AspectRatio != 2 {
+		t.Fatalf("size = %dx%d, aspect ratio = %g", placeholder.Width, placeholder.Height, placeholder.AspectRatio)
+	}
+
+	if rr := request("hash=md5"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400 for unknown hash", rr.Code)
+	}
+}
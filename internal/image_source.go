@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 type ImageSource struct {
@@ -20,9 +21,21 @@ func (imageSource *ImageSource) URLWithQueryString(r *http.Request) string {
 }
 
 func NewImageSourceFromHttpRequest(r *http.Request, config *Config) (*ImageSource, error) {
-	source := r.PathValue("source")
-	path := r.PathValue("path")
+	return NewImageSource(config, r.PathValue("source"), r.PathValue("path"))
+}
+
+// NewImageSourceFromLocation resolves a "source/path/to/file" string, as used
+// by query params referencing other images (e.g. watermarks).
+func NewImageSourceFromLocation(location string, config *Config) (*ImageSource, error) {
+	source, path, found := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	if !found || source == "" || path == "" {
+		return nil, fmt.Errorf("invalid image location: %s", location)
+	}
+
+	return NewImageSource(config, source, path)
+}
 
+func NewImageSource(config *Config, source string, path string) (*ImageSource, error) {
 	baseURL, exists := config.FindSourceByName(source)
 	if !exists {
 		return nil, fmt.Errorf("source not found: %s", source)
@@ -58,3 +58,24 @@ func TestImageSourceContextHelpers(t *testing.T) {
 		t.Fatalf("unexpected image source in context: %+v", got)
 	}
 }
+
+func TestNewImageSourceFromLocation(t *testing.T) {
+	cfg := &Config{Sources: []SourceConfig{{Name: "logos", URL: "https://cdn.example.com"}}}
+
+	source, err := NewImageSourceFromLocation("logos/brand/logo mark.png", cfg)
+	if err != nil {
+		t.Fatalf("NewImageSourceFromLocation() error: %v", err)
+	}
+	if source.Source != "logos" || source.Path != "brand/logo mark.png" {
+		t.Fatalf("source = %+v", source)
+	}
+	if source.URL != "https://cdn.example.com/brand/logo%20mark.png" {
+		t.Fatalf("URL = %q", source.URL)
+	}
+
+	for _, location := range []string{"", "logos", "logos/", "unknown/logo.png"} {
+		if _, err := NewImageSourceFromLocation(location, cfg); err == nil {
+			t.Fatalf("NewImageSourceFromLocation(%q) should fail", location)
+		}
+	}
+}
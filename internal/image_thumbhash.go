@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+)
+
+// ThumbHash input images must fit within this size.
+const maxThumbHashSize = 100
+
+// encodeThumbHash encodes RGBA pixels as a base64 ThumbHash
+// (https://evanw.github.io/thumbhash/), a port of the reference encoder.
+func encodeThumbHash(pixels []byte, width, height int) (string, error) {
+	if width <= 0 || height <= 0 || width > maxThumbHashSize || height > maxThumbHashSize {
+		return "", fmt.Errorf("%dx%d doesn't fit in %dx%d", width, height, maxThumbHashSize, maxThumbHashSize)
+	}
+	if len(pixels) < width*height*4 {
+		return "", fmt.Errorf("invalid pixel data for %dx%d", width, height)
+	}
+
+	count := width * height
+
+	// Average colour, weighted by alpha.
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < count; i++ {
+		alpha := float64(pixels[i*4+3]) / 255
+		avgR += alpha / 255 * float64(pixels[i*4])
+		avgG += alpha / 255 * float64(pixels[i*4+1])
+		avgB += alpha / 255 * float64(pixels[i*4+2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(count)
+
+	// Fewer luminance components are used when there's alpha.
+	lLimit := 7.0
+	if hasAlpha {
+		lLimit = 5
+	}
+	longest := float64(max(width, height))
+	lx := max(1, int(jsRound(lLimit*float64(width)/longest)))
+	ly := max(1, int(jsRound(lLimit*float64(height)/longest)))
+
+	// Convert to LPQA (luminance, yellow-blue, red-green, alpha), composited
+	// atop the average colour.
+	l := make([]float64, count)
+	p := make([]float64, count)
+	q := make([]float64, count)
+	a := make([]float64, count)
+	for i := 0; i < count; i++ {
+		alpha := float64(pixels[i*4+3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(pixels[i*4])
+		g := avgG*(1-alpha) + alpha/255*float64(pixels[i*4+1])
+		b := avgB*(1-alpha) + alpha/255*float64(pixels[i*4+2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	lDC, lAC, lScale := encodeThumbHashChannel(l, width, height, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeThumbHashChannel(p, width, height, 3, 3)
+	qDC, qAC, qScale := encodeThumbHashChannel(q, width, height, 3, 3)
+
+	isLandscape := width > height
+	header24 := int(jsRound(63*lDC)) | int(jsRound(31.5+31.5*pDC))<<6 | int(jsRound(31.5+31.5*qDC))<<12 | int(jsRound(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+
+	header16 := lx
+	if isLandscape {
+		header16 = ly
+	}
+	header16 |= int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if isLandscape {
+		header16 |= 1 << 15
+	}
+
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	acs := [][]float64{lAC, pAC, qAC}
+
+	if hasAlpha {
+		aDC, aAC, aScale := encodeThumbHashChannel(a, width, height, 5, 5)
+		hash = append(hash, byte(int(jsRound(15*aDC))|int(jsRound(15*aScale))<<4))
+		acs = append(acs, aAC)
+	}
+
+	// AC terms are packed as nibbles.
+	acStart, acIndex := len(hash), 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			if acStart+acIndex>>1 >= len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[acStart+acIndex>>1] |= byte(int(jsRound(15*f)) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
+
+// encodeThumbHashChannel encodes a channel using the DCT, returning the DC
+// (constant) term, the AC (varying) terms normalized to 0-1, and their scale.
+func encodeThumbHashChannel(channel []float64, width, height, nx, ny int) (float64, []float64, float64) {
+	var dc, scale float64
+	var ac []float64
+	fx := make([]float64, width)
+
+	for cy := 0; cy < ny; cy++ {
+		for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+			for x := 0; x < width; x++ {
+				fx[x] = math.Cos(math.Pi / float64(width) * float64(cx) * (float64(x) + 0.5))
+			}
+
+			f := 0.0
+			for y := 0; y < height; y++ {
+				fy := math.Cos(math.Pi / float64(height) * float64(cy) * (float64(y) + 0.5))
+				for x := 0; x < width; x++ {
+					f += channel[x+y*width] * fx[x] * fy
+				}
+			}
+			f /= float64(width * height)
+
+			if cx > 0 || cy > 0 {
+				ac = append(ac, f)
+				scale = math.Max(scale, math.Abs(f))
+			} else {
+				dc = f
+			}
+		}
+	}
+
+	if scale > 0 {
+		for i := range ac {
+			ac[i] = 0.5 + 0.5/scale*ac[i]
+		}
+	}
+
+	return dc, ac, scale
+}
+
+// jsRound rounds like JavaScript's Math.round (halves towards +Inf), which the
+// ThumbHash format relies on.
+func jsRound(value float64) float64 {
+	return math.Floor(value + 0.5)
+}
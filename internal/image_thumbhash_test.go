@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func decodeThumbHash(t *testing.T, pixels []byte, width, height int) []byte {
+	t.Helper()
+
+	hash, err := encodeThumbHash(pixels, width, height)
+	if err != nil {
+		t.Fatalf("encodeThumbHash() error: %v", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		t.Fatalf("base64 decode %q: %v", hash, err)
+	}
+
+	return decoded
+}
+
+func TestEncodeThumbHash(t *testing.T) {
+	// Square, opaque: 5 header bytes, then 27 + 5 + 5 AC nibbles.
+	hash := decodeThumbHash(t, solidPixels(32, 32, 255, 255, 255, 255), 32, 32)
+	if len(hash) != 5+19 {
+		t.Fatalf("len = %d, want 24", len(hash))
+	}
+	if hash[2]&0x80 != 0 || hash[4]&0x80 != 0 {
+		t.Fatalf("opaque square should have alpha and landscape flags unset: %v", hash[:5])
+	}
+	if lDC := hash[0] & 63; lDC != 63 {
+		t.Fatalf("white luminance = %d, want 63", lDC)
+	}
+
+	hash = decodeThumbHash(t, solidPixels(32, 16, 0, 0, 0, 255), 32, 16)
+	if hash[4]&0x80 == 0 {
+		t.Fatalf("landscape flag should be set: %v", hash[:5])
+	}
+	if lDC := hash[0] & 63; lDC != 0 {
+		t.Fatalf("black luminance = %d, want 0", lDC)
+	}
+
+	hash = decodeThumbHash(t, solidPixels(16, 16, 255, 0, 0, 128), 16, 16)
+	if hash[2]&0x80 == 0 {
+		t.Fatalf("alpha flag should be set: %v", hash[:6])
+	}
+
+	if _, err := encodeThumbHash(solidPixels(101, 1, 0, 0, 0, 255), 101, 1); err == nil {
+		t.Fatalf("encodeThumbHash() should reject images larger than 100x100")
+	}
+}
@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	maxToneMultiplier = 10
+	minGamma          = 0.1
+	maxGamma          = 10
+
+	gammaLookupTableCacheMaxEntries = 64
+)
+
+// govips has no binding for the libvips pow operation, so gamma goes through a
+// lookup table, built once per gamma value.
+var gammaLookupTables = &lookupTableCache{entries: make(map[float64]*vips.ImageRef)}
+
+func GrayscaleImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	return image.ToColorSpace(vips.InterpretationBW)
+}
+
+// BrightnessImage multiplies the lightness of the image (1 = unchanged).
+func BrightnessImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	return image.Modulate(imageOptions.Brightness, 1, 0)
+}
+
+// SaturationImage multiplies the chroma of the image (0 = greyscale, 1 = unchanged).
+func SaturationImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if err := ensureColorImage(image); err != nil {
+		return err
+	}
+
+	return image.Modulate(1, imageOptions.Saturation, 0)
+}
+
+// ContrastImage scales pixel values around the mid-point (1 = unchanged).
+func ContrastImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	contrast := imageOptions.Contrast
+
+	return withoutAlpha(image, func(image *vips.ImageRef) error {
+		format := image.BandFormat()
+
+		midpoint := 128.0
+		if format == vips.BandFormatUshort {
+			midpoint = 32768
+		}
+
+		if err := image.Linear1(contrast, midpoint*(1-contrast)); err != nil {
+			return err
+		}
+
+		return image.Cast(format)
+	})
+}
+
+// GammaImage applies gamma correction (values above 1 brighten mid-tones).
+func GammaImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Gamma <= 0 {
+		return fmt.Errorf("gamma must be greater than zero")
+	}
+
+	// The lookup table below has 256 entries, so make sure we're working on 8-bit data.
+	if image.BandFormat() != vips.BandFormatUchar {
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return err
+		}
+	}
+
+	lut, err := gammaLookupTables.Get(imageOptions.Gamma)
+	if err != nil {
+		return err
+	}
+	defer lut.Close()
+
+	return withoutAlpha(image, func(image *vips.ImageRef) error {
+		return image.Maplut(lut)
+	})
+}
+
+// TintImage maps the image luminance onto a single colour (black to imageOptions.Tint).
+func TintImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Tint == nil {
+		return fmt.Errorf("tint colour must be specified")
+	}
+
+	black := vips.ColorRGBA{A: 255}
+	return mapLuminance(image, black, *imageOptions.Tint)
+}
+
+// DuotoneImage maps the image luminance onto a gradient between two colours.
+func DuotoneImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	if imageOptions.Duotone == nil {
+		return fmt.Errorf("duotone colours must be specified")
+	}
+
+	return mapLuminance(image, imageOptions.Duotone[0], imageOptions.Duotone[1])
+}
+
+func mapLuminance(image *vips.ImageRef, shadow, highlight vips.ColorRGBA) error {
+	return withoutAlpha(image, func(image *vips.ImageRef) error {
+		// Going through B_W gives us luminance in three identical sRGB bands.
+		if err := image.ToColorSpace(vips.InterpretationBW); err != nil {
+			return err
+		}
+		if err := image.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return err
+		}
+
+		multipliers := []float64{
+			(float64(highlight.R) - float64(shadow.R)) / 255,
+			(float64(highlight.G) - float64(shadow.G)) / 255,
+			(float64(highlight.B) - float64(shadow.B)) / 255,
+		}
+		offsets := []float64{float64(shadow.R), float64(shadow.G), float64(shadow.B)}
+
+		if err := image.Linear(multipliers, offsets); err != nil {
+			return err
+		}
+
+		return image.Cast(vips.BandFormatUchar)
+	})
+}
+
+// withoutAlpha runs fn on the colour bands only, so that tone adjustments don't
+// affect transparency.
+func withoutAlpha(image *vips.ImageRef, fn func(*vips.ImageRef) error) error {
+	if !image.HasAlpha() {
+		return fn(image)
+	}
+
+	alpha, err := image.ExtractBandToImage(image.Bands()-1, 1)
+	if err != nil {
+		return err
+	}
+	defer alpha.Close()
+
+	if err := image.ExtractBand(0, image.Bands()-1); err != nil {
+		return err
+	}
+
+	if err := fn(image); err != nil {
+		return err
+	}
+
+	if alpha.BandFormat() != image.BandFormat() {
+		if err := alpha.Cast(image.BandFormat()); err != nil {
+			return err
+		}
+	}
+
+	return image.BandJoin(alpha)
+}
+
+// lookupTableCache keeps the lookup tables built by gammaLookupTable, keyed by
+// gamma. The oldest entries are evicted first.
+type lookupTableCache struct {
+	mu      sync.Mutex
+	entries map[float64]*vips.ImageRef
+	order   []float64
+}
+
+// Get returns a copy of the lookup table for gamma. The caller owns the
+// returned image and must close it.
+func (c *lookupTableCache) Get(gamma float64) (*vips.ImageRef, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lut, exists := c.entries[gamma]; exists {
+		return lut.Copy()
+	}
+
+	lut, err := gammaLookupTable(gamma)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.order) >= gammaLookupTableCacheMaxEntries {
+		c.entries[c.order[0]].Close()
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[gamma] = lut
+	c.order = append(c.order, gamma)
+
+	return lut.Copy()
+}
+
+func gammaLookupTable(gamma float64) (*vips.ImageRef, error) {
+	lut := image.NewGray(image.Rect(0, 0, 256, 1))
+	for i := 0; i < 256; i++ {
+		value := math.Pow(float64(i)/255, 1/gamma) * 255
+		lut.SetGray(i, 0, color.Gray{Y: uint8(math.Round(value))})
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, lut); err != nil {
+		return nil, err
+	}
+
+	return vips.LoadImageFromBuffer(buf.Bytes(), vips.NewImportParams())
+}
+
+// parseDuotone parses "shadow,highlight" hex colours, e.g. "1e3264,f0c8a0".
+func parseDuotone(value string) (*[2]vips.ColorRGBA, bool) {
+	shadowValue, highlightValue, found := strings.Cut(value, ",")
+	if !found {
+		return nil, false
+	}
+
+	shadow, ok := parseHexColor(shadowValue)
+	if !ok {
+		return nil, false
+	}
+
+	highlight, ok := parseHexColor(highlightValue)
+	if !ok {
+		return nil, false
+	}
+
+	return &[2]vips.ColorRGBA{shadow, highlight}, true
+}
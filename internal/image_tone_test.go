@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func transformPixel(t *testing.T, src []byte, opts *ImageOptions) color.RGBA {
+	t.Helper()
+
+	if opts.Format == vips.ImageTypeUnknown {
+		opts.Format = vips.ImageTypePNG
+	}
+
+	out, err := TransformImage(src, opts)
+	if err != nil {
+		t.Fatalf("TransformImage(%v) error: %v", opts.Operations, err)
+	}
+
+	return decodePixel(t, out.Bytes, 0, 0)
+}
+
+func isGrey(c color.RGBA) bool {
+	return c.R == c.G && c.G == c.B
+}
+
+// colorsClose allows for rounding in colourspace conversions.
+func colorsClose(a, b color.RGBA) bool {
+	near := func(x, y uint8) bool { return int(x)-int(y) <= 2 && int(y)-int(x) <= 2 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && near(a.A, b.A)
+}
+
+func TestGrayscaleAndSaturation(t *testing.T) {
+	src := makePNG(t, 10, 10)
+
+	if got := transformPixel(t, src, &ImageOptions{Operations: []string{"grayscale"}}); !isGrey(got) {
+		t.Fatalf("grayscale pixel = %v, want grey", got)
+	}
+
+	got := transformPixel(t, src, &ImageOptions{Operations: []string{"saturation"}, Saturation: 0})
+	if diff := int(got.R) - int(got.B); diff > 2 || diff < -2 {
+		t.Fatalf("desaturated pixel = %v, want (nearly) grey", got)
+	}
+}
+
+func TestBrightnessAndContrast(t *testing.T) {
+	src := makePNG(t, 10, 10)
+
+	brighter := transformPixel(t, src, &ImageOptions{Operations: []string{"brightness"}, Brightness: 1.3})
+	if brighter.G <= 100 {
+		t.Fatalf("brightened pixel = %v, want lighter than source", brighter)
+	}
+
+	flat := transformPixel(t, src, &ImageOptions{Operations: []string{"contrast"}, Contrast: 0})
+	if flat != (color.RGBA{R: 128, G: 128, B: 128, A: 255}) {
+		t.Fatalf("zero contrast pixel = %v, want mid-grey", flat)
+	}
+
+	unchanged := transformPixel(t, src, &ImageOptions{Operations: []string{"contrast"}, Contrast: 1})
+	if unchanged != (color.RGBA{R: 200, G: 100, B: 50, A: 255}) {
+		t.Fatalf("contrast=1 pixel = %v, want unchanged", unchanged)
+	}
+}
+
+func TestGamma(t *testing.T) {
+	src := makePNG(t, 10, 10)
+
+	got := transformPixel(t, src, &ImageOptions{Operations: []string{"gamma"}, Gamma: 2})
+	if got.G <= 100 || got.R <= 200 {
+		t.Fatalf("gamma=2 pixel = %v, want brighter mid-tones", got)
+	}
+
+	if _, err := TransformImage(src, &ImageOptions{Operations: []string{"gamma"}, Format: vips.ImageTypePNG}); err == nil {
+		t.Fatalf("expected error for zero gamma")
+	}
+}
+
+func TestLookupTableCache(t *testing.T) {
+	cache := &lookupTableCache{entries: make(map[float64]*vips.ImageRef)}
+
+	for i := 0; i <= gammaLookupTableCacheMaxEntries; i++ {
+		lut, err := cache.Get(1 + float64(i)/10)
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		if lut.Width() != 256 || lut.Height() != 1 {
+			t.Fatalf("lut size = %dx%d", lut.Width(), lut.Height())
+		}
+		lut.Close()
+	}
+
+	if len(cache.entries) != gammaLookupTableCacheMaxEntries || len(cache.order) != gammaLookupTableCacheMaxEntries {
+		t.Fatalf("entries = %d/%d, want %d", len(cache.entries), len(cache.order), gammaLookupTableCacheMaxEntries)
+	}
+	if _, exists := cache.entries[1]; exists {
+		t.Fatalf("the oldest entry should have been evicted")
+	}
+}
+
+func TestTintAndDuotone(t *testing.T) {
+	white := makeSplitPNG(t, 10, 10, color.RGBA{R: 255, G: 255, B: 255, A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	black := makeSplitPNG(t, 10, 10, color.RGBA{A: 255}, color.RGBA{A: 255})
+
+	tint := vips.ColorRGBA{R: 255, G: 0, B: 0, A: 255}
+	if got := transformPixel(t, white, &ImageOptions{Operations: []string{"tint"}, Tint: &tint}); !colorsClose(got, color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("tinted white = %v, want red", got)
+	}
+
+	duotone := &[2]vips.ColorRGBA{{R: 0, G: 0, B: 100, A: 255}, {R: 250, G: 200, B: 0, A: 255}}
+	if got := transformPixel(t, black, &ImageOptions{Operations: []string{"duotone"}, Duotone: duotone}); !colorsClose(got, color.RGBA{B: 100, A: 255}) {
+		t.Fatalf("duotone black = %v, want shadow colour", got)
+	}
+	if got := transformPixel(t, white, &ImageOptions{Operations: []string{"duotone"}, Duotone: duotone}); !colorsClose(got, color.RGBA{R: 250, G: 200, A: 255}) {
+		t.Fatalf("duotone white = %v, want highlight colour", got)
+	}
+
+	if _, err := TransformImage(white, &ImageOptions{Operations: []string{"tint"}, Format: vips.ImageTypePNG}); err == nil {
+		t.Fatalf("expected error when tint colour is missing")
+	}
+}
+
+func TestToneOperationsPreserveAlpha(t *testing.T) {
+	src := makeSplitPNG(t, 10, 10, color.RGBA{R: 200, A: 128}, color.RGBA{R: 200, A: 128})
+
+	got := transformPixel(t, src, &ImageOptions{Operations: []string{"contrast"}, Contrast: 0.5})
+	if got.A < 127 || got.A > 129 {
+		t.Fatalf("alpha = %d, want 128", got.A)
+	}
+}
+
+func TestParseDuotone(t *testing.T) {
+	duotone, ok := parseDuotone("000000,ffffff")
+	if !ok || duotone[0] != (vips.ColorRGBA{A: 255}) || duotone[1] != (vips.ColorRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("parseDuotone() = %v, %v", duotone, ok)
+	}
+
+	for _, value := range []string{"", "000000", "000000,nope", "nope,ffffff"} {
+		if _, ok := parseDuotone(value); ok {
+			t.Fatalf("parseDuotone(%q) should fail", value)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -13,8 +14,10 @@ import (
 )
 
 type ImageTransformHandler struct {
-	config *Config
-	sem    chan struct{}
+	config     *Config
+	sem        chan struct{}
+	watermarks *WatermarkCache
+	qualities  *QualityCache
 }
 
 func NewImageTransformHandler(config *Config) *ImageTransformHandler {
@@ -24,11 +27,29 @@ func NewImageTransformHandler(config *Config) *ImageTransformHandler {
 	}
 
 	return &ImageTransformHandler{
-		config: config,
-		sem:    make(chan struct{}, maxConcurrent),
+		config:     config,
+		sem:        make(chan struct{}, maxConcurrent),
+		watermarks: NewWatermarkCache(config),
+		qualities:  NewQualityCache(),
 	}
 }
 
+// formatQualitiesKey returns the qualities as a string, sorted by format.
+func formatQualitiesKey(qualities map[vips.ImageType]int) string {
+	formats := make([]vips.ImageType, 0, len(qualities))
+	for format := range qualities {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+
+	var key strings.Builder
+	for _, format := range formats {
+		fmt.Fprintf(&key, "%d:%d,", format, qualities[format])
+	}
+
+	return key.String()
+}
+
 func generateImageETag(sourceURL string, imageOptions *ImageOptions) string {
 	h := sha1.New()
 
@@ -36,16 +57,83 @@ func generateImageETag(sourceURL string, imageOptions *ImageOptions) string {
 	io.WriteString(h, fmt.Sprintf("%d", imageOptions.Width))
 	io.WriteString(h, fmt.Sprintf("%d", imageOptions.Height))
 	io.WriteString(h, fmt.Sprintf("%d", imageOptions.Quality))
+	io.WriteString(h, imageOptions.RequestedQuality)
+	if imageOptions.AutoQuality {
+		io.WriteString(h, fmt.Sprintf("%g", imageOptions.AutoQualityTarget))
+	}
+	if imageOptions.Format == vips.ImageTypeUnknown && imageOptions.RequestedQuality == "" {
+		// The quality depends on the source format, which isn't known yet.
+		io.WriteString(h, formatQualitiesKey(imageOptions.FormatQualities))
+	}
+	io.WriteString(h, fmt.Sprintf("%d", imageOptions.MaxBytes))
 	io.WriteString(h, fmt.Sprintf("%v", imageOptions.StripMetadata))
+	io.WriteString(h, imageOptions.Meta)
+	io.WriteString(h, fmt.Sprintf("%v", imageOptions.LQIP))
+	io.WriteString(h, imageOptions.Output)
+	io.WriteString(h, fmt.Sprintf("%v", imageOptions.AutoRotate))
+	io.WriteString(h, imageOptions.ICC)
 	io.WriteString(h, fmt.Sprintf("%v", imageOptions.Format))
 	io.WriteString(h, fmt.Sprintf("%s", imageOptions.RequestedFormat))
 	io.WriteString(h, fmt.Sprintf("%d", imageOptions.PixelateFactor))
 	io.WriteString(h, fmt.Sprintf("%d", imageOptions.Page))
+	io.WriteString(h, imageOptions.Gravity)
+	io.WriteString(h, fmt.Sprintf("%g,%g", imageOptions.FocalPointX, imageOptions.FocalPointY))
+	io.WriteString(h, fmt.Sprintf("%+v", imageOptions.Crop))
+	io.WriteString(h, fmt.Sprintf("%g", imageOptions.Angle))
+	io.WriteString(h, fmt.Sprintf("%+v", imageOptions.Background))
+	io.WriteString(h, fmt.Sprintf("%g", imageOptions.BlurSigma))
+	io.WriteString(h, fmt.Sprintf("%g,%g,%g", imageOptions.SharpenSigma, imageOptions.SharpenFlat, imageOptions.SharpenJagged))
+	io.WriteString(h, fmt.Sprintf("%g", imageOptions.TrimThreshold))
+	io.WriteString(h, fmt.Sprintf("%g,%g,%g,%g", imageOptions.Brightness, imageOptions.Contrast, imageOptions.Saturation, imageOptions.Gamma))
+	if imageOptions.Tint != nil {
+		io.WriteString(h, fmt.Sprintf("%+v", *imageOptions.Tint))
+	}
+	if imageOptions.Duotone != nil {
+		io.WriteString(h, fmt.Sprintf("%+v", *imageOptions.Duotone))
+	}
+	io.WriteString(h, fmt.Sprintf("%d,%v", imageOptions.Radius, imageOptions.RadiusCircle))
+	io.WriteString(h, imageOptions.Watermark)
+	io.WriteString(h, imageOptions.WatermarkVersion)
+	io.WriteString(h, fmt.Sprintf("%g", imageOptions.DPR))
+	io.WriteString(h, imageOptions.RequestedDPR)
+	io.WriteString(h, fmt.Sprintf("%v,%v,%d", imageOptions.Lossless, imageOptions.NearLossless, imageOptions.Effort))
+	if imageOptions.Progressive != nil {
+		io.WriteString(h, fmt.Sprintf("progressive=%v", *imageOptions.Progressive))
+	}
+	io.WriteString(h, fmt.Sprintf("%s,%d,%v,%d", imageOptions.Chroma, imageOptions.BitDepth, imageOptions.Palette, imageOptions.PaletteDepth))
+	if imageOptions.Compression != nil {
+		io.WriteString(h, fmt.Sprintf("compression=%d", *imageOptions.Compression))
+	}
+	io.WriteString(h, fmt.Sprintf("%v", imageOptions.Animated))
+	if imageOptions.Frame != nil {
+		io.WriteString(h, fmt.Sprintf("%d", *imageOptions.Frame))
+	}
+	io.WriteString(h, fmt.Sprintf("%v", imageOptions.FrameMiddle))
+	io.WriteString(h, imageOptions.WatermarkGravity)
+	io.WriteString(h, fmt.Sprintf("%d,%g,%g", imageOptions.WatermarkMargin, imageOptions.WatermarkOpacity, imageOptions.WatermarkScale))
 	io.WriteString(h, strings.Join(imageOptions.Operations, ","))
 
 	return fmt.Sprintf("\"%x\"", h.Sum(nil))
 }
 
+// imageQualityHeader reports the quality chosen for the output image.
+const imageQualityHeader = "X-Mediator-Quality"
+
+// imageVaryHeaders lists request headers the response depends on.
+func imageVaryHeaders(imageOptions *ImageOptions) []string {
+	var vary []string
+
+	if imageOptions.RequestedFormat == formatAuto {
+		vary = append(vary, "Accept")
+	}
+
+	if imageOptions.RequestedDPR == dprAuto {
+		vary = append(vary, "Sec-CH-DPR", "DPR")
+	}
+
+	return vary
+}
+
 func detectDownloadedImageType(downloadedFile *DownloadedFile) vips.ImageType {
 	imageType := ImageTypeFromMimeType(downloadedFile.ContentType)
 	if imageType != vips.ImageTypeUnknown {
@@ -55,9 +143,68 @@ func detectDownloadedImageType(downloadedFile *DownloadedFile) vips.ImageType {
 	return vips.DetermineImageType(downloadedFile.Buffer.Bytes())
 }
 
+// acquire waits for a free transform slot, responding with an error when the
+// request is cancelled first. Callers must release the slot when done.
+func (h *ImageTransformHandler) acquire(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case h.sem <- struct{}{}:
+		return true
+	case <-r.Context().Done():
+		http.Error(w, "Request cancelled", http.StatusServiceUnavailable)
+		return false
+	}
+}
+
+func (h *ImageTransformHandler) release() {
+	<-h.sem
+}
+
+// downloadImage downloads the source image, responding with an error when the
+// download fails or the image format isn't supported.
+func (h *ImageTransformHandler) downloadImage(w http.ResponseWriter, imageSource *ImageSource) (*DownloadedFile, vips.ImageType, bool) {
+	downloadedFile, err := DownloadFile(imageSource.URL, h.config.DownloadMaxSize, h.config.DownloadTimeout)
+	if err != nil {
+		slog.Error("Download error", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, vips.ImageTypeUnknown, false
+	}
+
+	downloadedImageType := detectDownloadedImageType(downloadedFile)
+	if downloadedImageType == vips.ImageTypeUnknown || !vips.IsTypeSupported(downloadedImageType) {
+		slog.Error("Unsupported image format: " + downloadedFile.ContentType)
+		http.Error(w, "Unsupported image format: "+downloadedFile.ContentType, http.StatusUnprocessableEntity)
+		return nil, vips.ImageTypeUnknown, false
+	}
+
+	return downloadedFile, downloadedImageType, true
+}
+
 func (h *ImageTransformHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	imageSource := getImageSource(r.Context())
+	setDefaultQueryParams(r, h.config.EncoderDefaults)
 	imageOptions := NewImageOptionsFromRequest(r)
+	if len(h.config.AutoFormats) > 0 {
+		imageOptions.negotiateFormat(r.Header.Get("Accept"), h.config.AutoFormats)
+	}
+
+	imageOptions.FormatQualities = h.config.FormatQuality
+	imageOptions.applyFormatQuality()
+	imageOptions.AutoQualityTarget = h.config.AutoQualityTarget
+
+	// The overlay is part of the ETag: replacing it at the same location must
+	// invalidate the watermarked images.
+	if hasOperation(imageOptions, "watermark") {
+		watermarkImage, watermarkVersion, err := h.watermarks.Get(imageOptions.Watermark)
+		if err != nil {
+			slog.Error("Watermark error", "watermark", imageOptions.Watermark, "error", err)
+			http.Error(w, "Watermark not available", http.StatusUnprocessableEntity)
+			return
+		}
+		defer watermarkImage.Close()
+
+		imageOptions.WatermarkImage = watermarkImage
+		imageOptions.WatermarkVersion = watermarkVersion
+	}
 
 	etag := generateImageETag(imageSource.URL, imageOptions)
 	w.Header().Set("ETag", etag)
@@ -70,36 +217,36 @@ func (h *ImageTransformHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	select {
-	case h.sem <- struct{}{}:
-		defer func() { <-h.sem }()
-	case <-r.Context().Done():
-		http.Error(w, "Request cancelled", http.StatusServiceUnavailable)
+	if !h.acquire(w, r) {
 		return
 	}
+	defer h.release()
 
-	downloadedFile, err := DownloadFile(imageSource.URL, h.config.DownloadMaxSize, h.config.DownloadTimeout)
-	if err != nil {
-		slog.Error("Download error", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	downloadedImageType := detectDownloadedImageType(downloadedFile)
-	if downloadedImageType == vips.ImageTypeUnknown || !vips.IsTypeSupported(downloadedImageType) {
-		slog.Error("Unsupported image format: "+downloadedFile.ContentType, "error", err)
-		http.Error(w, "Unsupported image format: "+downloadedFile.ContentType, http.StatusUnprocessableEntity)
+	downloadedFile, downloadedImageType, ok := h.downloadImage(w, imageSource)
+	if !ok {
 		return
 	}
 
 	if imageOptions.Format == vips.ImageTypeUnknown {
-		if IsImageExportSupported(downloadedImageType) {
+		if IsImageExportSupported(downloadedImageType) && !explicitOnlyExportFormats[downloadedImageType] {
 			imageOptions.Format = downloadedImageType
 		} else {
 			imageOptions.Format = vips.ImageTypeJPEG
 		}
 	}
 
+	// The format may only be known now, taken from the source image.
+	imageOptions.applyFormatQuality()
+
+	imageOptions.MaxFrames = h.config.MaxAnimationFrames
+
+	if imageOptions.AutoQuality {
+		if quality, exists := h.qualities.Get(etag); exists {
+			imageOptions.AutoQuality = false
+			imageOptions.Quality = quality
+		}
+	}
+
 	processedImage, err := TransformImage(downloadedFile.Buffer.Bytes(), imageOptions)
 	if err != nil {
 		slog.Error("TransformImage error", "error", err)
@@ -107,13 +254,32 @@ func (h *ImageTransformHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if imageOptions.RequestedFormat == "auto" {
-		w.Header().Set("Vary", "Accept")
+	if imageOptions.AutoQuality {
+		h.qualities.Set(etag, processedImage.Quality)
+	}
+
+	if vary := imageVaryHeaders(imageOptions); len(vary) > 0 {
+		w.Header().Set("Vary", strings.Join(vary, ", "))
 	}
 
 	w.Header().Set("Cache-Control", h.config.CacheControl)
-	w.Header().Set("Content-Type", processedImage.Mime)
-	w.Header().Set("Content-Length", strconv.Itoa(processedImage.Size))
-	w.WriteHeader(http.StatusOK)
-	w.Write(processedImage.Bytes)
+	if imageOptions.MaxBytes > 0 || imageOptions.RequestedQuality == qualityAuto {
+		w.Header().Set(imageQualityHeader, strconv.Itoa(processedImage.Quality))
+	}
+
+	switch imageOptions.Output {
+	case outputJSON:
+		writeJSONResponse(w, newInlineImage(processedImage))
+	case outputText:
+		uri := dataURI(processedImage)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(uri)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(uri))
+	default:
+		w.Header().Set("Content-Type", processedImage.Mime)
+		w.Header().Set("Content-Length", strconv.Itoa(processedImage.Size))
+		w.WriteHeader(http.StatusOK)
+		w.Write(processedImage.Bytes)
+	}
 }
@@ -1,7 +1,12 @@
 package internal
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/davidbyttow/govips/v2/vips"
 )
@@ -38,4 +43,168 @@ func TestGenerateImageETagIncludesAllOutputAffectingOptions(t *testing.T) {
 	if generateImageETag("https://cdn.example.com/file.jpg", &withRequestedFormat) == etagBase {
 		t.Fatalf("etag should change when requested format changes")
 	}
+
+	withFocalPoint := *base
+	withFocalPoint.FocalPointX = 0.3
+	if generateImageETag("https://cdn.example.com/file.jpg", &withFocalPoint) == etagBase {
+		t.Fatalf("etag should change when focal point changes")
+	}
+
+	withCrop := *base
+	withCrop.Crop = CropRect{X: 1, Y: 1, Width: 10, Height: 10}
+	if generateImageETag("https://cdn.example.com/file.jpg", &withCrop) == etagBase {
+		t.Fatalf("etag should change when crop changes")
+	}
+
+	withAngle := *base
+	withAngle.Angle = 90
+	if generateImageETag("https://cdn.example.com/file.jpg", &withAngle) == etagBase {
+		t.Fatalf("etag should change when angle changes")
+	}
+
+	withWatermark := *base
+	withWatermark.Watermark = "logos/logo.png"
+	if generateImageETag("https://cdn.example.com/file.jpg", &withWatermark) == etagBase {
+		t.Fatalf("etag should change when watermark changes")
+	}
+
+	withWatermarkVersion := withWatermark
+	withWatermarkVersion.WatermarkVersion = "2"
+	if generateImageETag("https://cdn.example.com/file.jpg", &withWatermarkVersion) == generateImageETag("https://cdn.example.com/file.jpg", &withWatermark) {
+		t.Fatalf("etag should change when the watermark overlay changes")
+	}
+
+	withDPR := *base
+	withDPR.RequestedDPR = "auto"
+	if generateImageETag("https://cdn.example.com/file.jpg", &withDPR) == etagBase {
+		t.Fatalf("etag should change when requested dpr changes")
+	}
+
+	withMaxBytes := *base
+	withMaxBytes.MaxBytes = 10000
+	if generateImageETag("https://cdn.example.com/file.jpg", &withMaxBytes) == etagBase {
+		t.Fatalf("etag should change when maxbytes changes")
+	}
+
+	withAutoQuality := *base
+	withAutoQuality.RequestedQuality = "auto"
+	if generateImageETag("https://cdn.example.com/file.jpg", &withAutoQuality) == etagBase {
+		t.Fatalf("etag should change when requested quality changes")
+	}
+
+	withAutoQuality.AutoQuality = true
+	withAutoQuality.AutoQualityTarget = 0.95
+	etagAutoQuality := generateImageETag("https://cdn.example.com/file.jpg", &withAutoQuality)
+	withAutoQuality.AutoQualityTarget = 0.99
+	if generateImageETag("https://cdn.example.com/file.jpg", &withAutoQuality) == etagAutoQuality {
+		t.Fatalf("etag should change with the auto quality target")
+	}
+
+	withMeta := *base
+	withMeta.Meta = metaCopyright
+	if generateImageETag("https://cdn.example.com/file.jpg", &withMeta) == etagBase {
+		t.Fatalf("etag should change when meta changes")
+	}
+
+	withSourceFormat := *base
+	withSourceFormat.Format = vips.ImageTypeUnknown
+	withSourceFormat.FormatQualities = map[vips.ImageType]int{vips.ImageTypeAVIF: 50}
+	etagSourceFormat := generateImageETag("https://cdn.example.com/file.jpg", &withSourceFormat)
+	withSourceFormat.FormatQualities = map[vips.ImageType]int{vips.ImageTypeAVIF: 60}
+	if generateImageETag("https://cdn.example.com/file.jpg", &withSourceFormat) == etagSourceFormat {
+		t.Fatalf("etag should change with the configured qualities when the format comes from the source")
+	}
+
+	withOutput := *base
+	withOutput.Output = outputJSON
+	if generateImageETag("https://cdn.example.com/file.jpg", &withOutput) == etagBase {
+		t.Fatalf("etag should change when output changes")
+	}
+}
+
+func TestImageVaryHeaders(t *testing.T) {
+	if got := imageVaryHeaders(&ImageOptions{}); len(got) != 0 {
+		t.Fatalf("imageVaryHeaders() = %v, want none", got)
+	}
+
+	got := imageVaryHeaders(&ImageOptions{RequestedFormat: "auto", RequestedDPR: "auto"})
+	if strings.Join(got, ", ") != "Accept, Sec-CH-DPR, DPR" {
+		t.Fatalf("imageVaryHeaders() = %v", got)
+	}
+}
+
+func TestImageTransformHandlerAutoQuality(t *testing.T) {
+	src := makeNoisePNG(t, 64, 64)
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	})
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/image/transform/src/img?format=jpeg&q=auto", nil)
+		req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := request()
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%q", first.Code, first.Body.String())
+	}
+
+	quality, err := strconv.Atoi(first.Header().Get(imageQualityHeader))
+	if err != nil || quality < minAutoQuality || quality > maxAutoQuality {
+		t.Fatalf("%s = %q", imageQualityHeader, first.Header().Get(imageQualityHeader))
+	}
+
+	if cached, exists := h.qualities.Get(first.Header().Get("ETag")); !exists || cached != quality {
+		t.Fatalf("cached quality = %d/%v, want %d", cached, exists, quality)
+	}
+
+	second := request()
+	if got := second.Header().Get(imageQualityHeader); got != strconv.Itoa(quality) {
+		t.Fatalf("second %s = %q, want %d", imageQualityHeader, got, quality)
+	}
+}
+
+func TestImageTransformHandlerLQIPIgnoresDPR(t *testing.T) {
+	src := makePNG(t, 64, 32)
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(src)
+	}))
+	defer srcServer.Close()
+
+	h := NewImageTransformHandler(&Config{
+		DownloadMaxSize: 1024 * 1024,
+		DownloadTimeout: 2 * time.Second,
+	})
+
+	etags := map[string]bool{}
+	for _, dpr := range []string{"1", "3"} {
+		req := httptest.NewRequest("GET", "http://example.com/image/transform/src/img?lqip=1&dpr=auto", nil)
+		req.Header.Set("Sec-CH-DPR", dpr)
+		req = req.WithContext(setImageSource(req.Context(), &ImageSource{URL: srcServer.URL + "/img"}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, body=%q", rr.Code, rr.Body.String())
+		}
+		if vary := rr.Header().Get("Vary"); strings.Contains(vary, "DPR") {
+			t.Fatalf("Vary = %q, want no DPR headers", vary)
+		}
+		etags[rr.Header().Get("ETag")] = true
+	}
+
+	if len(etags) != 1 {
+		t.Fatalf("etag should not depend on the DPR client hints, got %v", etags)
+	}
 }
@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"fmt"
+	"math"
 	"mime"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/davidbyttow/govips/v2/vips"
@@ -21,6 +25,17 @@ func ImageType(name string) vips.ImageType {
 		return vips.ImageTypeAVIF
 	case "heif", "heic":
 		return vips.ImageTypeHEIF
+	case "jxl":
+		// libvips may be built without libjxl, in which case the format is
+		// treated as unknown (and the source format is used instead).
+		if !vips.IsTypeSupported(vips.ImageTypeJXL) {
+			return vips.ImageTypeUnknown
+		}
+		return vips.ImageTypeJXL
+	case "tiff", "tif":
+		return vips.ImageTypeTIFF
+	case "bmp":
+		return vips.ImageTypeBMP
 	case "pdf":
 		return vips.ImageTypePDF
 	default:
@@ -28,6 +43,34 @@ func ImageType(name string) vips.ImageType {
 	}
 }
 
+// ImageTypeName returns the format name, as accepted by ImageType.
+func ImageTypeName(imageType vips.ImageType) string {
+	switch imageType {
+	case vips.ImageTypeJPEG:
+		return "jpeg"
+	case vips.ImageTypePNG:
+		return "png"
+	case vips.ImageTypeWEBP:
+		return "webp"
+	case vips.ImageTypeGIF:
+		return "gif"
+	case vips.ImageTypeAVIF:
+		return "avif"
+	case vips.ImageTypeHEIF:
+		return "heif"
+	case vips.ImageTypeJXL:
+		return "jxl"
+	case vips.ImageTypeTIFF:
+		return "tiff"
+	case vips.ImageTypeBMP:
+		return "bmp"
+	case vips.ImageTypePDF:
+		return "pdf"
+	default:
+		return "unknown"
+	}
+}
+
 func ImageTypeFromMimeType(mimeType string) vips.ImageType {
 	mediaType, _, _ := mime.ParseMediaType(mimeType)
 	switch mediaType {
@@ -43,6 +86,12 @@ func ImageTypeFromMimeType(mimeType string) vips.ImageType {
 		return vips.ImageTypeAVIF
 	case "image/heif", "image/heic":
 		return vips.ImageTypeHEIF
+	case "image/jxl":
+		return vips.ImageTypeJXL
+	case "image/tiff":
+		return vips.ImageTypeTIFF
+	case "image/bmp":
+		return vips.ImageTypeBMP
 	case "application/pdf":
 		return vips.ImageTypePDF
 	default:
@@ -50,28 +99,130 @@ func ImageTypeFromMimeType(mimeType string) vips.ImageType {
 	}
 }
 
-func ImageTypeFromAccept(accept string) vips.ImageType {
+// Formats returned for format=auto, in order of preference, unless configured
+// with MEDIATOR_AUTO_FORMATS.
+var defaultAutoFormats = []vips.ImageType{
+	vips.ImageTypeAVIF,
+	vips.ImageTypeJXL,
+	vips.ImageTypeWEBP,
+	vips.ImageTypeJPEG,
+	vips.ImageTypePNG,
+}
+
+// Formats every client can display. Only these are matched by wildcards
+// (image/*, */*) in the Accept header, as browsers send wildcards even when
+// they can't decode AVIF or WebP.
+var universalImageTypes = map[vips.ImageType]bool{
+	vips.ImageTypeJPEG: true,
+	vips.ImageTypePNG:  true,
+	vips.ImageTypeGIF:  true,
+}
+
+// NegotiateImageType picks the format from preferences with the highest
+// q-value in the Accept header. Ties go to the format listed first in
+// preferences. Returns vips.ImageTypeUnknown when none of them is acceptable.
+func NegotiateImageType(accept string, preferences []vips.ImageType) vips.ImageType {
+	weights := parseAccept(accept)
+
+	best, bestWeight := vips.ImageTypeUnknown, 0.0
+	for _, imageType := range preferences {
+		if !IsImageExportSupported(imageType) {
+			continue
+		}
+
+		if weight := acceptWeight(weights, imageType); weight > bestWeight {
+			best, bestWeight = imageType, weight
+		}
+	}
+
+	return best
+}
+
+// parseAccept maps the media ranges of an Accept header to their q-values.
+func parseAccept(accept string) map[string]float64 {
+	weights := make(map[string]float64)
+
 	for _, v := range strings.Split(accept, ",") {
-		mediaType, _, _ := mime.ParseMediaType(v)
-		switch mediaType {
-		case "image/avif":
-			return vips.ImageTypeAVIF
-		case "image/webp":
-			return vips.ImageTypeWEBP
-		case "image/png":
-			return vips.ImageTypePNG
-		case "image/jpeg":
-			return vips.ImageTypeJPEG
-		case "application/pdf":
-			return vips.ImageTypePDF
+		mediaType, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			continue
+		}
+
+		weight := 1.0
+		if q, exists := params["q"]; exists {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil || math.IsNaN(parsed) {
+				continue
+			}
+			weight = clampFloat(parsed, 0, 1)
+		}
+
+		weights[mediaType] = weight
+	}
+
+	return weights
+}
+
+// acceptWeight returns the q-value of the most specific media range matching
+// imageType.
+func acceptWeight(weights map[string]float64, imageType vips.ImageType) float64 {
+	if weight, exists := weights[MimeTypeFromImageType(imageType)]; exists {
+		return weight
+	}
+
+	if !universalImageTypes[imageType] {
+		return 0
+	}
+
+	if weight, exists := weights["image/*"]; exists {
+		return weight
+	}
+
+	return weights["*/*"]
+}
+
+// animatedImageTypes narrows preferences down to formats able to store
+// animations, with GIF as the last resort.
+func animatedImageTypes(preferences []vips.ImageType) []vips.ImageType {
+	var result []vips.ImageType
+	for _, imageType := range preferences {
+		if isAnimationSupported(imageType) {
+			result = append(result, imageType)
 		}
 	}
 
-	return vips.ImageTypeUnknown
+	if !slices.Contains(result, vips.ImageTypeGIF) {
+		result = append(result, vips.ImageTypeGIF)
+	}
+
+	return result
+}
+
+// parseImageTypes parses a comma-separated list of format names, e.g. "avif,webp,jpeg".
+func parseImageTypes(value string) ([]vips.ImageType, error) {
+	var result []vips.ImageType
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		imageType := ImageType(name)
+		if !IsImageExportSupported(imageType) {
+			return nil, fmt.Errorf("unsupported image format: %s", name)
+		}
+
+		result = append(result, imageType)
+	}
+
+	return result, nil
 }
 
 func MimeTypeFromImageType(code vips.ImageType) string {
 	switch code {
+	case vips.ImageTypeJPEG:
+		return "image/jpeg"
 	case vips.ImageTypePNG:
 		return "image/png"
 	case vips.ImageTypeAVIF:
@@ -82,7 +233,13 @@ func MimeTypeFromImageType(code vips.ImageType) string {
 		return "image/webp"
 	case vips.ImageTypeGIF:
 		return "image/gif"
+	case vips.ImageTypeJXL:
+		return "image/jxl"
+	case vips.ImageTypeTIFF:
+		return "image/tiff"
+	case vips.ImageTypeBMP:
+		return "image/bmp"
 	default:
-		return "image/jpeg"
+		return "application/octet-stream"
 	}
 }
@@ -17,6 +17,9 @@ func TestImageType(t *testing.T) {
 		"heif": vips.ImageTypeHEIF,
 		"heic": vips.ImageTypeHEIF,
 		"pdf":  vips.ImageTypePDF,
+		"tiff": vips.ImageTypeTIFF,
+		"tif":  vips.ImageTypeTIFF,
+		"bmp":  vips.ImageTypeBMP,
 	}
 
 	for input, want := range cases {
@@ -26,7 +29,7 @@ func TestImageType(t *testing.T) {
 	}
 }
 
-func TestImageTypeFromMimeTypeAndAccept(t *testing.T) {
+func TestImageTypeFromMimeType(t *testing.T) {
 	if got := ImageTypeFromMimeType("image/jpeg; charset=binary"); got != vips.ImageTypeJPEG {
 		t.Fatalf("ImageTypeFromMimeType() = %v", got)
 	}
@@ -40,13 +43,6 @@ func TestImageTypeFromMimeTypeAndAccept(t *testing.T) {
 	if got := ImageTypeFromMimeType("image/avif"); got != vips.ImageTypeAVIF {
 		t.Fatalf("ImageTypeFromMimeType(avif) = %v", got)
 	}
-
-	if got := ImageTypeFromAccept("image/avif,image/webp,image/jpeg"); got != vips.ImageTypeAVIF {
-		t.Fatalf("ImageTypeFromAccept() = %v, want AVIF", got)
-	}
-	if got := ImageTypeFromAccept("text/html"); got != vips.ImageTypeUnknown {
-		t.Fatalf("ImageTypeFromAccept(unknown) = %v", got)
-	}
 }
 
 func TestMimeTypeFromImageType(t *testing.T) {
@@ -65,7 +61,93 @@ func TestMimeTypeFromImageType(t *testing.T) {
 	if got := MimeTypeFromImageType(vips.ImageTypeGIF); got != "image/gif" {
 		t.Fatalf("MimeTypeFromImageType(GIF) = %q", got)
 	}
-	if got := MimeTypeFromImageType(vips.ImageTypeUnknown); got != "image/jpeg" {
+	if got := MimeTypeFromImageType(vips.ImageTypeJPEG); got != "image/jpeg" {
+		t.Fatalf("MimeTypeFromImageType(JPEG) = %q", got)
+	}
+	if got := MimeTypeFromImageType(vips.ImageTypeTIFF); got != "image/tiff" {
+		t.Fatalf("MimeTypeFromImageType(TIFF) = %q", got)
+	}
+	if got := MimeTypeFromImageType(vips.ImageTypeBMP); got != "image/bmp" {
+		t.Fatalf("MimeTypeFromImageType(BMP) = %q", got)
+	}
+	if got := MimeTypeFromImageType(vips.ImageTypeUnknown); got != "application/octet-stream" {
 		t.Fatalf("MimeTypeFromImageType(default) = %q", got)
 	}
 }
+
+func TestJXLImageType(t *testing.T) {
+	if got := ImageTypeFromMimeType("image/jxl"); got != vips.ImageTypeJXL {
+		t.Fatalf("ImageTypeFromMimeType(jxl) = %v", got)
+	}
+	if got := MimeTypeFromImageType(vips.ImageTypeJXL); got != "image/jxl" {
+		t.Fatalf("MimeTypeFromImageType(JXL) = %q", got)
+	}
+
+	// Without libjxl, JPEG XL must never be picked as an output format.
+	want, wantAccept := vips.ImageTypeUnknown, vips.ImageTypeWEBP
+	if vips.IsTypeSupported(vips.ImageTypeJXL) {
+		want, wantAccept = vips.ImageTypeJXL, vips.ImageTypeJXL
+	}
+
+	if got := ImageType("jxl"); got != want {
+		t.Fatalf("ImageType(jxl) = %v, want %v", got, want)
+	}
+	if got := NegotiateImageType("image/jxl,image/webp", defaultAutoFormats); got != wantAccept {
+		t.Fatalf("NegotiateImageType(jxl) = %v, want %v", got, wantAccept)
+	}
+}
+
+func TestNegotiateImageType(t *testing.T) {
+	preferences := []vips.ImageType{vips.ImageTypeWEBP, vips.ImageTypeJPEG, vips.ImageTypePNG}
+
+	cases := []struct {
+		accept string
+		want   vips.ImageType
+	}{
+		// Header order doesn't matter, server preferences break ties.
+		{"image/jpeg,image/webp", vips.ImageTypeWEBP},
+		{"image/webp;q=0.5,image/jpeg", vips.ImageTypeJPEG},
+		{"image/webp;q=0,*/*", vips.ImageTypeJPEG},
+		// Wildcards don't match modern formats.
+		{"image/*", vips.ImageTypeJPEG},
+		{"*/*;q=0.8", vips.ImageTypeJPEG},
+		{"image/png,image/*;q=0.8", vips.ImageTypePNG},
+		{"text/html", vips.ImageTypeUnknown},
+		{"", vips.ImageTypeUnknown},
+	}
+
+	for _, tc := range cases {
+		if got := NegotiateImageType(tc.accept, preferences); got != tc.want {
+			t.Fatalf("NegotiateImageType(%q) = %v, want %v", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestNegotiateImageTypeDefaultFormats(t *testing.T) {
+	cases := map[string]vips.ImageType{
+		"image/avif,image/webp,image/jpeg": vips.ImageTypeAVIF,
+		"image/png":                        vips.ImageTypePNG,
+		"image/png,*/*;q=0.8":              vips.ImageTypePNG,
+		"image/*":                          vips.ImageTypeJPEG,
+		"application/pdf":                  vips.ImageTypeUnknown,
+		"text/html":                        vips.ImageTypeUnknown,
+	}
+
+	for accept, want := range cases {
+		if got := NegotiateImageType(accept, defaultAutoFormats); got != want {
+			t.Fatalf("NegotiateImageType(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestImageTypeNameRoundTrip(t *testing.T) {
+	for _, name := range []string{"jpeg", "png", "webp", "gif", "avif", "heif", "tiff", "bmp", "pdf"} {
+		if got := ImageTypeName(ImageType(name)); got != name {
+			t.Fatalf("ImageTypeName(ImageType(%q)) = %q", name, got)
+		}
+	}
+
+	if got := ImageTypeName(vips.ImageTypeUnknown); got != "unknown" {
+		t.Fatalf("ImageTypeName(unknown) = %q", got)
+	}
+}
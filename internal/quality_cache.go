@@ -0,0 +1,41 @@
+package internal
+
+import "sync"
+
+const qualityCacheMaxEntries = 10000
+
+// QualityCache remembers the qualities picked with q=auto, keyed by ETag, so
+// that repeated requests for the same image skip the search. The oldest
+// entries are evicted first.
+type QualityCache struct {
+	mu      sync.Mutex
+	entries map[string]int
+	order   []string
+}
+
+func NewQualityCache() *QualityCache {
+	return &QualityCache{entries: make(map[string]int)}
+}
+
+func (c *QualityCache) Get(etag string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	quality, exists := c.entries[etag]
+	return quality, exists
+}
+
+func (c *QualityCache) Set(etag string, quality int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[etag]; !exists {
+		if len(c.order) >= qualityCacheMaxEntries {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, etag)
+	}
+
+	c.entries[etag] = quality
+}
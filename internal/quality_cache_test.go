@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQualityCache(t *testing.T) {
+	cache := NewQualityCache()
+
+	if _, exists := cache.Get("missing"); exists {
+		t.Fatalf("unexpected entry")
+	}
+
+	for i := 0; i <= qualityCacheMaxEntries; i++ {
+		cache.Set(fmt.Sprintf("etag-%d", i), i%100)
+	}
+
+	if _, exists := cache.Get("etag-0"); exists {
+		t.Fatalf("oldest entry should have been evicted")
+	}
+	if quality, exists := cache.Get(fmt.Sprintf("etag-%d", qualityCacheMaxEntries)); !exists || quality != qualityCacheMaxEntries%100 {
+		t.Fatalf("newest entry = %d/%v", quality, exists)
+	}
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	watermarkCacheMaxEntries = 32
+	watermarkCacheTTL        = 1 * time.Hour
+)
+
+type watermarkCacheEntry struct {
+	image    *vips.ImageRef
+	version  string
+	loadedAt time.Time
+}
+
+// WatermarkCache keeps decoded overlay images in memory, so that they're
+// downloaded once rather than on every watermarked request.
+type WatermarkCache struct {
+	config  *Config
+	mu      sync.Mutex
+	entries map[string]*watermarkCacheEntry
+}
+
+func NewWatermarkCache(config *Config) *WatermarkCache {
+	return &WatermarkCache{
+		config:  config,
+		entries: make(map[string]*watermarkCacheEntry),
+	}
+}
+
+// Get returns a copy of the overlay stored at location ("source/path"), along
+// with its version: a hash of the downloaded file, which changes when the
+// overlay is replaced. The caller owns the returned image and must close it.
+func (c *WatermarkCache) Get(location string) (*vips.ImageRef, string, error) {
+	imageSource, err := NewImageSourceFromLocation(location, c.config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.mu.Lock()
+	entry, exists := c.entries[imageSource.URL]
+	if exists && time.Since(entry.loadedAt) < watermarkCacheTTL {
+		defer c.mu.Unlock()
+		image, err := entry.image.Copy()
+		return image, entry.version, err
+	}
+	c.mu.Unlock()
+
+	image, version, err := c.load(imageSource.URL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(imageSource.URL, image, version)
+
+	copied, err := image.Copy()
+	return copied, version, err
+}
+
+func (c *WatermarkCache) load(url string) (*vips.ImageRef, string, error) {
+	slog.Debug("Loading watermark", "url", url)
+
+	downloadedFile, err := DownloadFile(url, c.config.DownloadMaxSize, c.config.DownloadTimeout)
+	if err != nil {
+		return nil, "", err
+	}
+
+	imageType := detectDownloadedImageType(downloadedFile)
+	if imageType == vips.ImageTypeUnknown || !vips.IsTypeSupported(imageType) {
+		return nil, "", fmt.Errorf("unsupported watermark format: %s", downloadedFile.ContentType)
+	}
+
+	image, err := vips.LoadImageFromBuffer(downloadedFile.Buffer.Bytes(), vips.NewImportParams())
+	if err != nil {
+		return nil, "", err
+	}
+
+	return image, fmt.Sprintf("%x", sha1.Sum(downloadedFile.Buffer.Bytes())), nil
+}
+
+// store must be called with c.mu held.
+func (c *WatermarkCache) store(url string, image *vips.ImageRef, version string) {
+	if previous, exists := c.entries[url]; exists {
+		previous.image.Close()
+		delete(c.entries, url)
+	}
+
+	if len(c.entries) >= watermarkCacheMaxEntries {
+		var oldestURL string
+		var oldest *watermarkCacheEntry
+
+		for entryURL, entry := range c.entries {
+			if oldest == nil || entry.loadedAt.Before(oldest.loadedAt) {
+				oldestURL, oldest = entryURL, entry
+			}
+		}
+
+		oldest.image.Close()
+		delete(c.entries, oldestURL)
+	}
+
+	c.entries[url] = &watermarkCacheEntry{image: image, version: version, loadedAt: time.Now()}
+}
+
+// WatermarkImage composites imageOptions.WatermarkImage over the image, scaled
+// relative to the image width and placed according to the watermark gravity.
+func WatermarkImage(image *vips.ImageRef, imageOptions *ImageOptions) error {
+	overlay := imageOptions.WatermarkImage
+	if overlay == nil {
+		return fmt.Errorf("watermark image must be specified")
+	}
+
+	if err := applyOrientation(image, imageOptions); err != nil {
+		return err
+	}
+
+	margin := imageOptions.WatermarkMargin
+	availableWidth := image.Width() - 2*margin
+	availableHeight := image.Height() - 2*margin
+
+	if availableWidth <= 0 || availableHeight <= 0 || overlay.Width() == 0 || overlay.Height() == 0 {
+		return fmt.Errorf("image too small for watermark")
+	}
+
+	scale := 1.0
+	if imageOptions.WatermarkScale > 0 {
+		scale = imageOptions.WatermarkScale * float64(image.Width()) / float64(overlay.Width())
+	}
+
+	// Never let the overlay spill outside of the margins.
+	scale = math.Min(scale, float64(availableWidth)/float64(overlay.Width()))
+	scale = math.Min(scale, float64(availableHeight)/float64(overlay.Height()))
+
+	if scale != 1.0 {
+		if err := overlay.Resize(scale, vips.KernelLanczos3); err != nil {
+			return err
+		}
+	}
+
+	if err := overlay.AddAlpha(); err != nil {
+		return err
+	}
+
+	if imageOptions.WatermarkOpacity < 1 {
+		if err := applyOpacity(overlay, imageOptions.WatermarkOpacity); err != nil {
+			return err
+		}
+	}
+
+	focalX, focalY := focalPointForGravity(imageOptions.WatermarkGravity)
+	left := margin + int(math.Round(focalX*float64(availableWidth-overlay.Width())))
+	top := margin + int(math.Round(focalY*float64(availableHeight-overlay.Height())))
+
+	hadAlpha := image.HasAlpha()
+
+	if err := image.Composite(overlay, vips.BlendModeOver, left, top); err != nil {
+		return err
+	}
+
+	// Compositing always produces an alpha band; drop it again for opaque
+	// images so that the output doesn't grow for no reason.
+	if !hadAlpha {
+		return image.ExtractBand(0, image.Bands()-1)
+	}
+
+	return nil
+}
+
+func applyOpacity(image *vips.ImageRef, opacity float64) error {
+	format := image.BandFormat()
+	bands := image.Bands()
+	multipliers := make([]float64, bands)
+	offsets := make([]float64, bands)
+
+	for i := range multipliers {
+		multipliers[i] = 1
+	}
+	multipliers[bands-1] = clampFloat(opacity, 0, 1)
+
+	if err := image.Linear(multipliers, offsets); err != nil {
+		return err
+	}
+
+	return image.Cast(format)
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestWatermarkCacheDownloadsOnce(t *testing.T) {
+	var hits atomic.Int32
+	overlay := makePNG(t, 10, 10)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(overlay)
+	}))
+	defer upstream.Close()
+
+	cache := NewWatermarkCache(&Config{
+		Sources:         []SourceConfig{{Name: "logos", URL: upstream.URL}},
+		DownloadMaxSize: 50 * MB,
+		DownloadTimeout: 5 * time.Second,
+	})
+
+	for i := 0; i < 3; i++ {
+		img, _, err := cache.Get("logos/brand/logo.png")
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		if img.Width() != 10 || img.Height() != 10 {
+			t.Fatalf("overlay size = %dx%d, want 10x10", img.Width(), img.Height())
+		}
+		img.Close()
+	}
+
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("upstream hits = %d, want 1", got)
+	}
+}
+
+func TestWatermarkCacheVersion(t *testing.T) {
+	var overlay atomic.Value
+	overlay.Store(makePNG(t, 10, 10))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(overlay.Load().([]byte))
+	}))
+	defer upstream.Close()
+
+	cache := NewWatermarkCache(&Config{
+		Sources:         []SourceConfig{{Name: "logos", URL: upstream.URL}},
+		DownloadMaxSize: 50 * MB,
+		DownloadTimeout: 5 * time.Second,
+	})
+
+	get := func() string {
+		img, version, err := cache.Get("logos/logo.png")
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		img.Close()
+		return version
+	}
+
+	first := get()
+	if first == "" || get() != first {
+		t.Fatalf("version should be stable while the overlay is cached")
+	}
+
+	// Replace the overlay and let the cached entry expire.
+	overlay.Store(makePNG(t, 12, 12))
+	for _, entry := range cache.entries {
+		entry.loadedAt = time.Now().Add(-watermarkCacheTTL)
+	}
+
+	if get() == first {
+		t.Fatalf("version should change when the overlay is replaced")
+	}
+}
+
+func TestWatermarkCacheUnknownSource(t *testing.T) {
+	cache := NewWatermarkCache(&Config{})
+
+	if _, _, err := cache.Get("missing/logo.png"); err == nil {
+		t.Fatalf("expected error for unknown source")
+	}
+}
+
+func TestTransformImageWatermarkPlacement(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	overlay, err := vips.LoadImageFromBuffer(makeSplitPNG(t, 10, 10, red, red), nil)
+	if err != nil {
+		t.Fatalf("LoadImageFromBuffer(): %v", err)
+	}
+	defer overlay.Close()
+
+	opts := &ImageOptions{
+		Operations:       []string{"watermark"},
+		Format:           vips.ImageTypePNG,
+		WatermarkImage:   overlay,
+		WatermarkGravity: "south-east",
+		WatermarkMargin:  2,
+		WatermarkOpacity: 1,
+		WatermarkScale:   0.25,
+	}
+
+	out, err := TransformImage(makeSplitPNG(t, 40, 40, blue, blue), opts)
+	if err != nil {
+		t.Fatalf("TransformImage() error: %v", err)
+	}
+
+	w, h := decodeImageSize(t, out.Bytes)
+	if w != 40 || h != 40 {
+		t.Fatalf("result size = %dx%d, want 40x40", w, h)
+	}
+
+	// 10px overlay (25% of 40px) placed 2px from the bottom-right corner.
+	if got := decodePixel(t, out.Bytes, 33, 33); got != red {
+		t.Fatalf("watermark pixel = %v, want %v", got, red)
+	}
+	if got := decodePixel(t, out.Bytes, 39, 39); got != blue {
+		t.Fatalf("margin pixel = %v, want %v", got, blue)
+	}
+	if got := decodePixel(t, out.Bytes, 5, 5); got != blue {
+		t.Fatalf("base pixel = %v, want %v", got, blue)
+	}
+}
+
+func TestTransformImageWatermarkRequiresOverlay(t *testing.T) {
+	opts := &ImageOptions{Operations: []string{"watermark"}, Format: vips.ImageTypePNG}
+
+	if _, err := TransformImage(makePNG(t, 40, 40), opts); err == nil {
+		t.Fatalf("expected error when watermark image is missing")
+	}
+}